@@ -0,0 +1,104 @@
+package birc
+
+import (
+	"context"
+	"sync"
+)
+
+type result struct {
+	m   Message
+	err error
+}
+
+type waiter struct {
+	match func(m Message) bool
+	ch    chan result
+}
+
+type waiters struct {
+	sync.Mutex
+	list []*waiter
+}
+
+// WaitFor blocks until the Channel receives a message for which match returns
+// true, and returns it. It returns ctx's error if ctx is done first, or ErrClosed
+// if the listener stops. Only messages received after WaitFor is called are
+// considered.
+func (c *Channel) WaitFor(ctx context.Context, match func(m Message) bool) (Message, error) {
+	w := c.await(match)
+	return c.wait(ctx, w)
+}
+
+// Request sends m and waits for the first reply for which match returns true,
+// e.g. the NOTICE confirming a chat command. The reply can't be missed because
+// the Channel starts watching for it before m is sent.
+func (c *Channel) Request(ctx context.Context, m *Message, match func(m Message) bool) (Message, error) {
+	w := c.await(match)
+	if err := c.SendMessage(m); err != nil {
+		c.forget(w)
+		return Message{}, err
+	}
+	return c.wait(ctx, w)
+}
+
+func (c *Channel) await(match func(m Message) bool) *waiter {
+	w := &waiter{match: match, ch: make(chan result, 1)}
+
+	// The state is checked under the lock so a listener that is stopping
+	// either sees this waiter in release or has already marked itself closed.
+	c.waiters.Lock()
+	defer c.waiters.Unlock()
+	if c.State() == StateClosed {
+		w.ch <- result{err: ErrClosed}
+		return w
+	}
+	c.waiters.list = append(c.waiters.list, w)
+	return w
+}
+
+func (c *Channel) wait(ctx context.Context, w *waiter) (Message, error) {
+	select {
+	case r := <-w.ch:
+		return r.m, r.err
+	case <-ctx.Done():
+		c.forget(w)
+		return Message{}, ctx.Err()
+	}
+}
+
+func (c *Channel) forget(w *waiter) {
+	c.waiters.Lock()
+	defer c.waiters.Unlock()
+	for i, other := range c.waiters.list {
+		if other == w {
+			c.waiters.list = append(c.waiters.list[:i], c.waiters.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify hands m to every waiter it matches.
+func (c *Channel) notify(m Message) {
+	c.waiters.Lock()
+	defer c.waiters.Unlock()
+
+	list := c.waiters.list[:0]
+	for _, w := range c.waiters.list {
+		if w.match(m) {
+			w.ch <- result{m: m}
+			continue
+		}
+		list = append(list, w)
+	}
+	c.waiters.list = list
+}
+
+// release fails every waiter once the listener stops.
+func (c *Channel) release() {
+	c.waiters.Lock()
+	defer c.waiters.Unlock()
+	for _, w := range c.waiters.list {
+		w.ch <- result{err: ErrClosed}
+	}
+	c.waiters.list = nil
+}
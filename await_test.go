@@ -0,0 +1,94 @@
+package birc_test
+
+import (
+	"bufio"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestWaitFor(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	go func() {
+		conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+		conn.Write([]byte("@room-id=1;slow=0 :tmi.twitch.tv ROOMSTATE #test\r\n"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := c.WaitFor(ctx, func(m birc.Message) bool {
+		return m.Command == "ROOMSTATE"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Tags["room-id"] != "1" {
+		t.Errorf("expected ROOMSTATE for room 1, got %+v", m)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	c, _ := connected(t)
+	defer c.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitFor(ctx, func(birc.Message) bool { return true }); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	// Answer the request as soon as it arrives.
+	go func() {
+		line, _, _ := bufio.NewReader(conn).ReadLine()
+		if string(line) == "PRIVMSG #test :/slow 10" {
+			conn.Write([]byte("@msg-id=slow_on :tmi.twitch.tv NOTICE #test :This room is now in slow mode.\r\n"))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := c.Request(ctx, &birc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"#test"},
+		Content: "/slow 10",
+		Trusted: true,
+	}, func(m birc.Message) bool {
+		return m.Command == "NOTICE" && m.Tags["msg-id"] == "slow_on"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Content != "This room is now in slow mode." {
+		t.Errorf("unexpected reply %+v", m)
+	}
+}
+
+func TestWaitForClosed(t *testing.T) {
+	c, conn := connected(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.WaitFor(context.Background(), func(birc.Message) bool { return false })
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-errs:
+		if err != birc.ErrClosed {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitFor to return when the listener stopped")
+	}
+}
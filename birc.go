@@ -1,6 +1,10 @@
 package birc
 
-import sirc "github.com/sorcix/irc"
+import (
+	"time"
+
+	sirc "github.com/sorcix/irc"
+)
 
 const (
 	// DefaultTwitchPort is Twitch's default IRC port
@@ -14,6 +18,15 @@ const (
 	DefaultTwitchServer = DefaultTwitchURI + ":" + DefaultTwitchPort
 	// DefaultTwitchTlsServer is the default TLS server and port
 	DefaultTwitchTlsServer = DefaultTwitchURI + ":" + DefaultTwitchTlsPort
+	// DefaultSilenceTimeout is how long a connection may go without receiving
+	// a line before it is considered dead and reconnected. Twitch sends a PING
+	// roughly every five minutes.
+	DefaultSilenceTimeout = 10 * time.Minute
+	// MaxMessageLength is the most characters Twitch accepts in a chat message.
+	MaxMessageLength = 500
+	// MaxLineLength is the most bytes an encoded IRC line may have, without
+	// the line ending. Longer lines are cut off by the encoder.
+	MaxLineLength = 510
 )
 
 // Encoder represents a struct capable of encoding an IRC message.
@@ -0,0 +1,164 @@
+package birctest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jpiontek/bitter-irc"
+	sirc "github.com/sorcix/irc"
+)
+
+// Recorder is a birc.ChannelWriter that records what a digester sends instead
+// of writing it to a connection, so digesters can be tested on their own. It
+// also records errors reported through birc.ReportError, and can stand in for
+// a connection as a Channel's writer, see birc.Channel.SetWriter. It is safe
+// for concurrent use.
+type Recorder struct {
+	config birc.Config
+
+	mu     sync.Mutex
+	sent   []birc.Message
+	errs   []error
+	fail   error
+	failAt int
+}
+
+// NewRecorder returns a Recorder for the named channel, sending as username.
+func NewRecorder(channel, username string) *Recorder {
+	return &Recorder{config: birc.Config{
+		ChannelName: strings.TrimPrefix(channel, "#"),
+		Username:    username,
+		Server:      birc.DefaultTwitchServer,
+	}}
+}
+
+// Send records a chat message to the channel.
+func (r *Recorder) Send(content string) error {
+	return r.SendMessage(&birc.Message{
+		Name:     r.config.Username,
+		Username: r.config.Username,
+		Content:  content,
+		Command:  "PRIVMSG",
+		Params:   []string{"#" + r.config.ChannelName},
+	})
+}
+
+// SendMessage records m as a Channel would send it, sanitized with
+// birc.Sanitize. Like a Channel it rejects messages that are too long, and it
+// returns the error set with FailWith once that takes effect. Rejected messages
+// are not recorded.
+func (r *Recorder) SendMessage(m *birc.Message) error {
+	sanitized, err := birc.Sanitize(m)
+	if err != nil {
+		return err
+	}
+
+	return r.record(*sanitized)
+}
+
+// Encode records an encoded message, making the Recorder a birc.Encoder.
+func (r *Recorder) Encode(m *sirc.Message) error {
+	decoded := birc.ParseMessage(m.String())
+	if decoded == nil {
+		return fmt.Errorf("birctest: invalid message %q", m.String())
+	}
+	return r.record(*decoded)
+}
+
+func (r *Recorder) record(m birc.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		if r.failAt == 0 {
+			return r.fail
+		}
+		r.failAt--
+	}
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+// GetConfig returns the Recorder's configuration.
+func (r *Recorder) GetConfig() birc.Config {
+	return r.config
+}
+
+// ReportError records err, making the Recorder a birc.ErrorReporter.
+func (r *Recorder) ReportError(err error, m birc.Message) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+}
+
+// FailWith makes every send after the next n successful ones return err. A
+// nil err makes sends succeed again.
+func (r *Recorder) FailWith(err error, n int) {
+	r.mu.Lock()
+	r.fail, r.failAt = err, n
+	r.mu.Unlock()
+}
+
+// Messages returns every recorded message.
+func (r *Recorder) Messages() []birc.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]birc.Message(nil), r.sent...)
+}
+
+// Sent returns the content of every recorded chat message.
+func (r *Recorder) Sent() []string {
+	var sent []string
+	for _, m := range r.Messages() {
+		if m.Command == "PRIVMSG" {
+			sent = append(sent, m.Content)
+		}
+	}
+	return sent
+}
+
+// Errors returns every reported error.
+func (r *Recorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errs...)
+}
+
+// Reset forgets everything recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.sent, r.errs = nil, nil
+	r.mu.Unlock()
+}
+
+// AssertSent fails the test unless exactly the supplied chat messages were
+// sent, in order.
+func (r *Recorder) AssertSent(t testing.TB, contents ...string) {
+	t.Helper()
+	sent := r.Sent()
+	if fmt.Sprintf("%q", sent) != fmt.Sprintf("%q", contents) {
+		t.Errorf("expected %q to be sent, got %q", contents, sent)
+	}
+}
+
+// AssertSentContaining fails the test unless a chat message containing substr
+// was sent.
+func (r *Recorder) AssertSentContaining(t testing.TB, substr string) {
+	t.Helper()
+	sent := r.Sent()
+	for _, content := range sent {
+		if strings.Contains(content, substr) {
+			return
+		}
+	}
+	t.Errorf("expected a message containing %q to be sent, got %q", substr, sent)
+}
+
+// AssertNothingSent fails the test if any message was sent.
+func (r *Recorder) AssertNothingSent(t testing.TB) {
+	t.Helper()
+	if sent := r.Messages(); len(sent) > 0 {
+		t.Errorf("expected nothing to be sent, got %d messages", len(sent))
+	}
+}
@@ -0,0 +1,77 @@
+package birctest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestRecorder(t *testing.T) {
+	router := birc.NewRouter("!")
+	router.Handle(birc.Command{
+		Name: "echo",
+		Args: []birc.Arg{{Name: "text", Type: birc.ArgRest}},
+		Handler: func(m birc.Message, args birc.Args, w birc.ChannelWriter) {
+			w.Send(args.String("text"))
+		},
+	})
+
+	r := birctest.NewRecorder("#test", "foobar")
+	router.Digest(*birc.ParseMessage(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :!echo hi there"), r)
+	router.Digest(*birc.ParseMessage(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :!echo"), r)
+	r.AssertSent(t, "hi there", "Usage: !echo <text...>")
+	r.AssertSentContaining(t, "Usage")
+
+	if m := r.Messages()[0]; m.Params[0] != "#test" || m.Username != "foobar" {
+		t.Errorf("expected a message to #test from foobar, got %+v", m)
+	}
+
+	r.Reset()
+	r.AssertNothingSent(t)
+}
+
+func TestRecorderSanitizes(t *testing.T) {
+	r := birctest.NewRecorder("test", "foobar")
+	r.Send("/ban bob\r\nPRIVMSG #other :hi")
+	r.SendMessage(&birc.Message{Command: "privmsg", Params: []string{"#test :x", ""}, Content: "hi"})
+
+	r.AssertSent(t, "ban bob  PRIVMSG #other :hi", "hi")
+	if m := r.Messages()[1]; m.Command != "PRIVMSG" || len(m.Params) != 1 || m.Params[0] != "#test:x" {
+		t.Errorf("expected the command and params to be sanitized, got %+v", m)
+	}
+	if err := r.Send(strings.Repeat("\U0001F389", 200)); err != birc.ErrMessageTooLong {
+		t.Errorf("expected ErrMessageTooLong for a line over %d bytes, got %v", birc.MaxLineLength, err)
+	}
+}
+
+func TestRecorderErrors(t *testing.T) {
+	r := birctest.NewRecorder("test", "foobar")
+
+	if err := r.Send(strings.Repeat("a", birc.MaxMessageLength+1)); err != birc.ErrMessageTooLong {
+		t.Errorf("expected ErrMessageTooLong, got %v", err)
+	}
+
+	r.FailWith(birc.ErrClosed, 1)
+	if err := r.Send("first"); err != nil {
+		t.Errorf("expected the first send to succeed, got %v", err)
+	}
+	if err := r.Send("second"); err != birc.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	r.FailWith(nil, 0)
+	if err := r.Send("third"); err != nil {
+		t.Errorf("expected sends to succeed again, got %v", err)
+	}
+	r.AssertSent(t, "first", "third")
+
+	failing := birc.Errors(func(m birc.Message, w birc.ChannelWriter) error {
+		return errors.New("boom")
+	})
+	failing(birc.Message{}, r)
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "boom" {
+		t.Errorf("expected the reported error to be recorded, got %v", errs)
+	}
+}
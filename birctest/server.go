@@ -0,0 +1,377 @@
+// Package birctest provides utilities for testing code built on birc.
+package birctest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Host is the name the Server uses as the prefix of its own messages.
+const Host = "tmi.twitch.tv"
+
+// Server is an in-process fake of Twitch's IRC server. It answers the login,
+// capability and JOIN sequence the way Twitch does, records every line clients
+// send and can be scripted to inject messages and failures.
+type Server struct {
+	// Addr is the address to connect to, e.g. "127.0.0.1:53412".
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	accepted int
+	received []string
+	changed  chan struct{}
+	closed   bool
+}
+
+// NewServer starts a Server listening on a random local port. It panics if it
+// can't listen, since there is no sensible way for a test to continue.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("birctest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		Addr:     l.Addr().String(),
+		listener: l,
+		conns:    map[*conn]struct{}{},
+		changed:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops the Server and closes every client connection.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.listener.Close()
+	s.Drop()
+	s.wg.Wait()
+}
+
+// Accepted returns the number of connections accepted so far.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Received returns every line received from clients so far, in order.
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// WaitFor blocks until a client sends a line for which match returns true,
+// including lines received before it was called, and returns the first such
+// line. It returns ctx's error if ctx is done first.
+func (s *Server) WaitFor(ctx context.Context, match func(line string) bool) (string, error) {
+	seen := 0
+	for {
+		s.mu.Lock()
+		lines, changed := s.received[seen:], s.changed
+		seen = len(s.received)
+		s.mu.Unlock()
+
+		for _, line := range lines {
+			if match(line) {
+				return line, nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Inject sends a raw line, such as "@badges= :bob!bob@bob.tmi.twitch.tv
+// PRIVMSG #test :hi", to every connected client.
+func (s *Server) Inject(line string) {
+	for _, c := range s.clients() {
+		c.write(line)
+	}
+}
+
+// Chat sends a chat message from user to every client that joined channel.
+// The tags are only sent to clients that requested the tags capability.
+func (s *Server) Chat(channel, user, content string, tags map[string]string) {
+	channel = "#" + strings.TrimPrefix(channel, "#")
+	for _, c := range s.clients() {
+		if c.joined(channel) {
+			c.send(tags, fmt.Sprintf("%s PRIVMSG %s :%s", userPrefix(user), channel, content))
+		}
+	}
+}
+
+// Reconnect asks every client to reconnect, like Twitch does before restarting
+// a server.
+func (s *Server) Reconnect() {
+	s.Inject(":" + Host + " RECONNECT")
+}
+
+// RateLimit tells every client that joined channel that its last message was
+// dropped for being sent too quickly.
+func (s *Server) RateLimit(channel string) {
+	channel = "#" + strings.TrimPrefix(channel, "#")
+	for _, c := range s.clients() {
+		if c.joined(channel) {
+			c.send(map[string]string{"msg-id": "msg_ratelimit"}, fmt.Sprintf(
+				":%s NOTICE %s :Your message was not sent because you are sending messages too quickly.", Host, channel))
+		}
+	}
+}
+
+// Drop abruptly closes every client connection without a goodbye.
+func (s *Server) Drop() {
+	for _, c := range s.clients() {
+		c.Close()
+	}
+}
+
+func (s *Server) clients() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{Conn: nc, channels: map[string]bool{}}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) record(line string) {
+	s.mu.Lock()
+	s.received = append(s.received, line)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *Server) serve(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		s.record(line)
+		if !c.reply(line) {
+			return
+		}
+	}
+}
+
+// conn is a client connection and what the client registered on it.
+type conn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	nick     string
+	tags     bool
+	channels map[string]bool
+}
+
+func (c *conn) write(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Write([]byte(line + "\r\n"))
+}
+
+// send writes line, prefixed with tags if the client requested them.
+func (c *conn) send(tags map[string]string, line string) {
+	c.mu.Lock()
+	withTags := c.tags
+	c.mu.Unlock()
+	if withTags && len(tags) > 0 {
+		line = FormatTags(tags) + " " + line
+	}
+	c.write(line)
+}
+
+func (c *conn) joined(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[channel]
+}
+
+// reply answers a line from the client the way Twitch would. It returns false
+// once the client has quit.
+func (c *conn) reply(line string) bool {
+	command, params := split(line)
+	c.mu.Lock()
+	nick := c.nick
+	c.mu.Unlock()
+
+	switch command {
+	case "NICK":
+		if len(params) == 0 {
+			break
+		}
+		nick = strings.ToLower(params[0])
+		c.mu.Lock()
+		c.nick = nick
+		c.mu.Unlock()
+		for _, l := range []string{
+			"001 %[1]s :Welcome, GLHF!",
+			"002 %[1]s :Your host is " + Host,
+			"003 %[1]s :This server is rather new",
+			"004 %[1]s :-",
+			"375 %[1]s :-",
+			"372 %[1]s :You are in a maze of twisty passages, all alike.",
+			"376 %[1]s :>",
+		} {
+			c.write(":" + Host + " " + fmt.Sprintf(l, nick))
+		}
+	case "CAP":
+		if len(params) < 2 || params[0] != "REQ" {
+			break
+		}
+		for _, capability := range strings.Fields(params[1]) {
+			if capability == "twitch.tv/tags" {
+				c.mu.Lock()
+				c.tags = true
+				c.mu.Unlock()
+			}
+		}
+		c.write(fmt.Sprintf(":%s CAP * ACK :%s", Host, params[1]))
+	case "JOIN":
+		if len(params) == 0 {
+			break
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			c.mu.Lock()
+			c.channels[channel] = true
+			c.mu.Unlock()
+
+			c.write(fmt.Sprintf("%s JOIN %s", userPrefix(nick), channel))
+			c.write(fmt.Sprintf(":%s.%s 353 %s = %s :%s", nick, Host, nick, channel, nick))
+			c.write(fmt.Sprintf(":%s.%s 366 %s %s :End of /NAMES list", nick, Host, nick, channel))
+			c.send(map[string]string{
+				"badge-info":   "",
+				"badges":       "",
+				"color":        "",
+				"display-name": nick,
+				"emote-sets":   "0",
+				"mod":          "0",
+				"subscriber":   "0",
+				"user-type":    "",
+			}, fmt.Sprintf(":%s USERSTATE %s", Host, channel))
+			c.send(map[string]string{
+				"emote-only":     "0",
+				"followers-only": "-1",
+				"r9k":            "0",
+				"room-id":        "12345",
+				"slow":           "0",
+				"subs-only":      "0",
+			}, fmt.Sprintf(":%s ROOMSTATE %s", Host, channel))
+		}
+	case "PART":
+		if len(params) == 0 {
+			break
+		}
+		c.mu.Lock()
+		delete(c.channels, params[0])
+		c.mu.Unlock()
+		c.write(fmt.Sprintf("%s PART %s", userPrefix(nick), params[0]))
+	case "PING":
+		c.write(fmt.Sprintf(":%s PONG %s :%s", Host, Host, strings.Join(params, " ")))
+	case "QUIT":
+		return false
+	}
+	return true
+}
+
+// split returns a line's command and parameters, ignoring any tags or prefix.
+func split(line string) (string, []string) {
+	if strings.HasPrefix(line, "@") || strings.HasPrefix(line, ":") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+
+	var trailing *string
+	if i := strings.Index(line, " :"); i >= 0 {
+		t := line[i+2:]
+		trailing = &t
+		line = line[:i]
+	}
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return "", nil
+	}
+	params := words[1:]
+	if trailing != nil {
+		params = append(params, *trailing)
+	}
+	return strings.ToUpper(words[0]), params
+}
+
+func userPrefix(user string) string {
+	return fmt.Sprintf(":%[1]s!%[1]s@%[1]s.%[2]s", user, Host)
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+
+// FormatTags encodes tags as the tags section of a line, including the
+// leading @. Keys are sorted so the output is stable.
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + tagEscaper.Replace(tags[k])
+	}
+	return "@" + strings.Join(parts, ";")
+}
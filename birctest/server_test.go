@@ -0,0 +1,116 @@
+package birctest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestServer(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	messages := make(chan birc.Message, 10)
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, func(m birc.Message, w birc.ChannelWriter) {
+		if m.Command == "PRIVMSG" || m.Command == "ROOMSTATE" {
+			messages <- m
+		}
+	})
+	c.Config.Server = server.Addr
+	c.SetDispatcher(birc.Ordered(0, birc.OverflowBlock))
+
+	errs := make(chan error, 1)
+	c.SetErrorHandler(func(err error, m birc.Message) { errs <- err })
+	joined := make(chan bool, 2)
+	c.OnJoin(func(c *birc.Channel) { joined <- true })
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	listening := make(chan error, 1)
+	go func() { listening <- c.Listen() }()
+
+	expect(t, joined)
+	if m := next(t, messages); m.Command != "ROOMSTATE" || m.Tags["room-id"] != "12345" {
+		t.Errorf("expected a tagged ROOMSTATE, got %+v", m)
+	}
+
+	server.Chat("test", "bob", "hi there", map[string]string{"display-name": "Bob", "badges": "moderator/1"})
+	if m := next(t, messages); m.Username != "bob" || m.Content != "hi there" || m.Tags["display-name"] != "Bob" {
+		t.Errorf("unexpected chat message %+v", m)
+	}
+
+	c.Send("hello")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := server.WaitFor(ctx, func(line string) bool {
+		return strings.HasSuffix(line, "PRIVMSG #test :hello")
+	}); err != nil {
+		t.Errorf("expected the server to record the chat message: %s", err)
+	}
+	if received := server.Received(); received[2] != "PASS oauth:abc123" || received[3] != "NICK foobar" {
+		t.Errorf("expected the login to be recorded after the capabilities, got %q", received)
+	}
+
+	server.RateLimit("#test")
+	select {
+	case err := <-errs:
+		if !errors.Is(err, birc.ErrRateLimited) {
+			t.Errorf("expected ErrRateLimited, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("timed out waiting for the rate limit")
+	}
+
+	server.Reconnect()
+	expect(t, joined)
+	if server.Accepted() != 2 {
+		t.Errorf("expected the client to reconnect, got %d connections", server.Accepted())
+	}
+	if m := next(t, messages); m.Command != "ROOMSTATE" {
+		t.Errorf("expected a ROOMSTATE after rejoining, got %+v", m)
+	}
+
+	server.Drop()
+	select {
+	case err := <-listening:
+		if err == nil {
+			t.Error("expected the dropped connection to end the listener with an error")
+		}
+	case <-time.After(time.Second):
+		t.Error("timed out waiting for the listener to stop")
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tags := birctest.FormatTags(map[string]string{"system-msg": `hi there;\`, "badges": ""})
+	if tags != `@badges=;system-msg=hi\sthere\:\\` {
+		t.Errorf("unexpected tags %s", tags)
+	}
+}
+
+func expect(t *testing.T, ch chan bool) {
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the channel to join")
+	}
+}
+
+func next(t *testing.T, messages chan birc.Message) birc.Message {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return birc.Message{}
+}
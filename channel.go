@@ -1,9 +1,14 @@
 package birc
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	sirc "github.com/sorcix/irc"
@@ -15,17 +20,38 @@ type Config struct {
 	Server      string
 	Username    string
 	OAuthToken  string
-	tls         bool
+	// SilenceTimeout is how long the connection may stay silent before it's
+	// forcibly reconnected. Defaults to DefaultSilenceTimeout.
+	SilenceTimeout time.Duration
+	tls            bool
 }
 
 // Channel represents a connected and active IRC channel.
 type Channel struct {
-	Config     *Config
-	Digesters  []Digester
-	connection net.Conn
-	reader     Decoder
-	writer     Encoder
-	done       chan error
+	Config       *Config
+	Digesters    []Digester
+	connMu       sync.RWMutex
+	reconnectMu  sync.Mutex
+	connection   net.Conn
+	reader       *bufio.Reader
+	writer       Encoder
+	stateMu      sync.RWMutex
+	state        State
+	hooks        hooks
+	mu           sync.Mutex
+	closing      bool
+	listening    bool
+	sending      sync.WaitGroup
+	dispatcher   Dispatcher
+	errorHandler ErrorHandler
+	stats        stats
+	registry     registry
+	waiters      waiters
+	messages     stream[Message]
+	events       stream[Event]
+	lifetime     lifetime
+	lines        lineHooks
+	clock        Clock
 }
 
 // ChannelWriter represents a writer capable of sending messages to a channel.
@@ -59,6 +85,10 @@ func NewTwitchChannel(channelName, username, token string, tls bool, digesters .
 
 // Connect establishes a connection to an IRC server.
 func (c *Channel) Connect() error {
+	if c.State() != StateReconnecting {
+		c.setState(StateConnecting)
+	}
+
 	var err error
 	var conn net.Conn
 	if c.Config.tls {
@@ -68,27 +98,59 @@ func (c *Channel) Connect() error {
 	}
 
 	if err != nil {
-		return err
+		if c.State() == StateConnecting {
+			c.setState(StateDisconnected)
+		}
+		return &NetError{Op: "dial", Err: err}
 	}
 
+	c.renewContext()
+	c.messages.reopen()
+	c.events.reopen()
+	c.connMu.Lock()
 	c.connection = conn
-	c.reader = sirc.NewDecoder(conn)
+	c.reader = bufio.NewReader(conn)
 	c.writer = sirc.NewEncoder(conn)
-	if c.done == nil {
-		c.done = make(chan error)
-	}
+	c.connMu.Unlock()
+	c.transition(StateConnected)
 	return nil
 }
 
 // SetWriter sets the channel's underlying writer. This is not threadsafe.
 func (c *Channel) SetWriter(e Encoder) {
+	c.connMu.Lock()
 	c.writer = e
+	c.connMu.Unlock()
+}
+
+// conn returns the current connection and its reader and encoder, which are
+// replaced on every reconnect.
+func (c *Channel) conn() (net.Conn, *bufio.Reader, Encoder) {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.connection, c.reader, c.writer
 }
 
 // Authenticate sends the PASS and NICK to authenticate against the server. It also sends
 // the JOIN message in order to join the specified channel in the configuration.
 func (c *Channel) Authenticate() error {
+	_, _, w := c.conn()
+	if w == nil {
+		return ErrNotConnected
+	}
+	c.setState(StateAuthenticating)
+
 	for _, m := range []sirc.Message{
+		// Twitch specific capability registration. The capabilities are
+		// requested first so the replies to JOIN are already tagged.
+		sirc.Message{
+			Command: "CAP REQ",
+			Params:  []string{":twitch.tv/commands"},
+		},
+		sirc.Message{
+			Command: "CAP REQ",
+			Params:  []string{":twitch.tv/tags"},
+		},
 		sirc.Message{
 			Command: sirc.PASS,
 			Params:  []string{fmt.Sprintf("oauth:%s", c.Config.OAuthToken)},
@@ -101,102 +163,297 @@ func (c *Channel) Authenticate() error {
 			Command: sirc.JOIN,
 			Params:  []string{fmt.Sprintf("#%s", c.Config.ChannelName)},
 		},
-		// Twitch specific capability registration
-		sirc.Message{
-			Command: "CAP REQ",
-			Params:  []string{":twitch.tv/commands"},
-		},
-		//sirc.Message{
-		//Command: "CAP REQ",
-		//Params:  []string{":twitch.tv/tags"},
-		//},
 	} {
-		if err := c.writer.Encode(&m); err != nil {
-			return err
+		if err := c.encode(w, &m); err != nil {
+			return &NetError{Op: "write", Err: err}
 		}
 	}
 	return nil
 }
 
-// Disconnect ends the current listener and closes the TCP connection.
-func (c *Channel) Disconnect() {
-	c.done <- nil
+// Disconnect ends the current listener and closes the TCP connection without
+// waiting for pending sends or digesters, see Shutdown. It returns
+// ErrNotConnected if the Channel never connected and ErrClosed if it's already
+// closed, including after the listener returned.
+func (c *Channel) Disconnect() error {
+	conn, _, _ := c.conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	c.mu.Lock()
+	if c.closing || c.State() == StateClosed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closing = true
+	listening := c.listening
+	c.mu.Unlock()
+	c.cancelContext()
+
+	err := conn.Close()
+	if !listening {
+		c.closed(nil)
+	}
+	return err
+}
+
+// Shutdown gracefully closes the Channel. It stops accepting new sends, waits for
+// pending sends to be written, PARTs the channel, sends QUIT and waits for running
+// digesters to return before closing the connection. If ctx is done first the
+// connection is closed immediately and ctx's error is returned. If the Channel
+// isn't listening, Shutdown also moves it to StateClosed and releases its
+// waiters and streams, which Listen does otherwise. It returns ErrNotConnected,
+// leaving the Channel as it is, if the Channel never connected.
+func (c *Channel) Shutdown(ctx context.Context) error {
+	conn, _, w := c.conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closing = true
+	listening := c.listening
+	c.mu.Unlock()
+	c.cancelContext()
+
+	drained := make(chan error, 1)
+	go func() {
+		c.sending.Wait()
+		for _, m := range []sirc.Message{
+			sirc.Message{
+				Command: sirc.PART,
+				Params:  []string{fmt.Sprintf("#%s", c.Config.ChannelName)},
+			},
+			sirc.Message{
+				Command: sirc.QUIT,
+			},
+		} {
+			if err := c.encode(w, &m); err != nil {
+				drained <- err
+				return
+			}
+		}
+		c.getDispatcher().Wait()
+		drained <- nil
+	}()
+
+	var err error
+	select {
+	case err = <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	// Without a listener nothing else closes the Channel and releases its
+	// waiters and streams.
+	if !listening {
+		c.closed(err)
+	}
+	return err
+}
+
+func (c *Channel) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
 }
 
 // Send writes a message to the channel.
 func (c *Channel) Send(content string) error {
-	return c.SendMessage(&Message{
+	return c.SendMessage(c.chat(content))
+}
+
+// SendTrusted writes a message to the channel without stripping chat commands,
+// so it can be used to run commands such as /slow. It must not be used with
+// content that may come from other users.
+func (c *Channel) SendTrusted(content string) error {
+	m := c.chat(content)
+	m.Trusted = true
+	return c.SendMessage(m)
+}
+
+// chat returns a chat message with content from the Channel's user.
+func (c *Channel) chat(content string) *Message {
+	return &Message{
 		Name:     c.Config.Username,
 		Username: c.Config.Username,
 		Content:  content,
 		Command:  sirc.PRIVMSG,
 		Params:   []string{fmt.Sprintf("#%s", c.Config.ChannelName)},
-	})
+	}
 }
 
-// SendMessage sends the supplied message to the Channel.
+// SendMessage sends the supplied message to the Channel, sanitized with
+// Sanitize. Chat messages longer than MaxMessageLength characters, and messages
+// that would be encoded into a line longer than MaxLineLength bytes, are
+// rejected with ErrMessageTooLong rather than cut off.
 func (c *Channel) SendMessage(message *Message) error {
-	if err := c.writer.Encode(message.prepare()); err != nil {
+	sanitized, err := Sanitize(message)
+	if err != nil {
 		return err
 	}
+
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.sending.Add(1)
+	c.mu.Unlock()
+	defer c.sending.Done()
+
+	_, _, w := c.conn()
+	if w == nil {
+		return ErrNotConnected
+	}
+	if err := c.encode(w, sanitized.prepare()); err != nil {
+		return &NetError{Op: "write", Err: err}
+	}
 	return nil
 }
 
 // Listen enters a loop and starts decoding IRC messages from the connected channel.
-// Decoded messages are pushed to the digesters to be handled.
+// Decoded messages are pushed to the digesters to be handled. It returns
+// ErrClosed if the Channel was shut down.
 func (c *Channel) Listen() error {
-	// Close the connection when finished.
-	defer c.connection.Close()
+	if conn, _, _ := c.conn(); conn == nil {
+		return ErrNotConnected
+	}
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.listening = true
+	c.mu.Unlock()
 
-	return c.startReceiving()
+	// Close the connection when finished. Reconnects replace the connection,
+	// so it must be read when the listener exits.
+	defer func() {
+		conn, _, _ := c.conn()
+		conn.Close()
+	}()
+
+	err := c.startReceiving()
+	c.closed(err)
+	return err
 }
 
 func (c *Channel) startReceiving() error {
+	wd := c.watch()
+	defer func() { wd.stop() }()
+
 	for {
-		select {
-		case <-c.done:
-			return nil
-		default:
-			c.connection.SetDeadline(time.Now().Add(10 * time.Minute))
-			m, err := c.reader.Decode()
-			if err != nil {
-				return err
-			}
-			// If the message is a PING command from Twitch, respond with a PONG
-			// without pushing the message through to the digesters
-			if m.Command == "PING" {
-				c.SendMessage(PongMessage())
-				break
+		_, r, _ := c.conn()
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if c.isClosing() {
+				return nil
 			}
-
-			// Handle Twitch restarting their IRC servers.
-			if m.Command == "RECONNECT" {
-				err := c.Reconnect()
-				if err != nil {
+			// The watchdog closed a silent connection, reconnect.
+			if wd.stop() {
+				c.count(func(s *Stats) { s.ForcedReconnects++ })
+				if err := c.Reconnect(); err != nil {
+					c.count(func(s *Stats) { s.Errors++ })
 					return err
 				}
-				break
+				wd = c.watch()
+				continue
 			}
+			// Reconnect was called from another goroutine, which closed the
+			// connection to replace it. Keep reading the new one.
+			if c.replaced(r) {
+				wd = c.watch()
+				continue
+			}
+			c.count(func(s *Stats) { s.Errors++ })
+			return &NetError{Op: "read", Err: err}
+		}
+		wd.kick()
+		now := c.Clock().Now()
+		c.count(func(s *Stats) { s.LastInbound = now })
+
+		m := c.receive(line, now)
+		if m == nil {
+			continue
+		}
 
-			message := &Message{
-				Content: m.Trailing,
-				Command: m.Command,
-				Params:  m.Params,
-				Time:    time.Now(),
+		// If the message is a PING command from Twitch, respond with a PONG
+		// without pushing the message through to the digesters
+		if m.Command == "PING" {
+			if err := c.SendMessage(PongMessage()); err != nil {
+				c.ReportError(err, *m)
 			}
-			if m.Prefix != nil {
-				message.Name = m.Name
-				message.Username = m.User
-				message.Content = m.Trailing
-				message.Host = m.Host
+			continue
+		}
+
+		// Handle Twitch restarting their IRC servers.
+		if m.Command == "RECONNECT" {
+			wd.stop()
+			c.count(func(s *Stats) { s.Reconnects++ })
+			err := c.Reconnect()
+			if err != nil {
+				c.count(func(s *Stats) { s.Errors++ })
+				return err
 			}
-			c.handle(message)
+			wd = c.watch()
+			continue
+		}
+
+		if err := c.deliver(m, c.getDispatcher()); err != nil {
+			c.count(func(s *Stats) { s.Errors++ })
+			return err
 		}
 	}
 }
 
-// Reconnect reconnects and reauthenticates the Channel.
+// receive filters and parses a line received at time now. It returns nil if
+// the line was dropped or isn't a valid message.
+func (c *Channel) receive(line string, now time.Time) *Message {
+	line, keep := c.inbound(line)
+	if !keep {
+		return nil
+	}
+	m := ParseMessage(line)
+	if m == nil {
+		return nil
+	}
+	m.Time = now
+	return m
+}
+
+// deliver tracks m and passes it to the streams, waiters and, through d, the
+// digesters.
+func (c *Channel) deliver(m *Message, d Dispatcher) error {
+	if err := c.track(m); err != nil {
+		return err
+	}
+	c.publishMessage(*m)
+	if e := ParseEvent(*m); e != nil {
+		c.publishEvent(e)
+	}
+	c.notify(*m)
+	c.handle(m, d)
+	return nil
+}
+
+// Reconnect reconnects and reauthenticates the Channel. It may be called from
+// any goroutine, the listener carries on reading the new connection.
 func (c *Channel) Reconnect() error {
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+	c.setState(StateReconnecting)
+
+	if conn, _, _ := c.conn(); conn != nil {
+		conn.Close()
+	}
+
 	err := c.Connect()
 	if err != nil {
 		return err
@@ -207,11 +464,46 @@ func (c *Channel) Reconnect() error {
 		return err
 	}
 
+	c.reconnected()
+	return nil
+}
+
+// replaced waits for a running Reconnect and reports whether it replaced the
+// connection r was reading from.
+func (c *Channel) replaced(r *bufio.Reader) bool {
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+	_, current, _ := c.conn()
+	return current != r
+}
+
+// track advances the Channel's state based on the server's replies.
+// A rejected login is returned as an error that stops the listener, while
+// rate limit notices are passed to the ErrorHandler.
+func (c *Channel) track(m *Message) error {
+	switch m.Command {
+	case sirc.RPL_WELCOME:
+		c.transition(StateAuthenticated)
+	case sirc.JOIN:
+		if strings.EqualFold(m.Name, c.Config.Username) &&
+			len(m.Params) > 0 && strings.EqualFold(m.Params[0], "#"+c.Config.ChannelName) {
+			c.transition(StateJoined)
+		}
+	case sirc.NOTICE:
+		err := &NoticeError{ID: m.Tags["msg-id"], Text: m.Content}
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+		if errors.Is(err, ErrRateLimited) {
+			c.ReportError(err, *m)
+		}
+	}
 	return nil
 }
 
-func (c *Channel) handle(m *Message) {
-	for _, d := range c.Digesters {
-		go d(*m, c)
+func (c *Channel) handle(m *Message, d Dispatcher) {
+	if c.isClosing() {
+		return
 	}
+	d.Dispatch(*m, c, c.digesters())
 }
@@ -2,11 +2,14 @@ package birc_test
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
 	sirc "github.com/sorcix/irc"
 )
 
@@ -44,17 +47,14 @@ func TestNewTwitchChannel(t *testing.T) {
 }
 
 func TestConnect(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
 	config := &birc.Config{
 		ChannelName: "test",
 		Username:    "foobar",
 		OAuthToken:  "abc123",
-		Server:      "127.0.0.1:4444",
-	}
-
-	// Create a listener on the same port as the test configuration
-	l, err := net.Listen("tcp", config.Server)
-	if err != nil {
-		t.Error(err)
+		Server:      server.Addr,
 	}
 
 	var digesters = []birc.Digester{birc.Logger}
@@ -69,26 +69,21 @@ func TestConnect(t *testing.T) {
 		t.Error("Expected Logger digester")
 	}
 
-	err = c.Connect()
+	err := c.Connect()
 	if err != nil {
 		t.Error(err)
 	}
-
-	l.Close()
 }
 
 func TestConnectionError(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
 	config := &birc.Config{
 		ChannelName: "test",
 		Username:    "foobar",
 		OAuthToken:  "abc123",
-		Server:      "127.0.0.1:4444",
-	}
-
-	// Create a listener on the same port as the test configuration
-	l, err := net.Listen("tcp", config.Server)
-	if err != nil {
-		t.Error(err)
+		Server:      server.Addr,
 	}
 
 	c := &birc.Channel{Config: config}
@@ -97,7 +92,7 @@ func TestConnectionError(t *testing.T) {
 		t.Error("Expected a channel")
 	}
 
-	err = c.Connect()
+	err := c.Connect()
 	if err != nil {
 		t.Error(err)
 	}
@@ -113,10 +108,12 @@ func TestConnectionError(t *testing.T) {
 		ch <- err
 	}()
 
-	// Close the listener to simulate losing connection to the server
-	go func() {
-		l.Close()
-	}()
+	// Wait for the login to finish, then drop the connection to simulate
+	// losing connection to the server
+	if _, err := server.WaitFor(timeout(t), equals("JOIN #test")); err != nil {
+		t.Fatal(err)
+	}
+	server.Drop()
 
 	select {
 	case err := <-ch:
@@ -124,23 +121,20 @@ func TestConnectionError(t *testing.T) {
 		if err == nil {
 			t.Error("expected error")
 		}
+	case <-time.After(time.Second):
+		t.Error("expected Listen to return")
 	}
-
-	l.Close()
 }
 
 func TestPing(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
 	config := &birc.Config{
 		ChannelName: "test",
 		Username:    "foobar",
 		OAuthToken:  "abc123",
-		Server:      "127.0.0.1:4444",
-	}
-
-	// Create a listener on the same port as the test configuration
-	l, err := net.Listen("tcp", config.Server)
-	if err != nil {
-		t.Error(err)
+		Server:      server.Addr,
 	}
 
 	c := &birc.Channel{Config: config}
@@ -149,39 +143,25 @@ func TestPing(t *testing.T) {
 		t.Error("Expected a channel")
 	}
 
-	err = c.Connect()
+	err := c.Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	ch := make(chan string, 1)
-	connection, _ := l.Accept()
-	// start a go routine for the tcp server to listen to messages,
-	// when it receives one send it out to the ch channel
-	go func(conn net.Conn) {
-		for {
-			message, _, err := bufio.NewReader(conn).ReadLine()
-			if err != nil {
-				ch <- err.Error()
-			}
-			ch <- string(message)
-			break
-		}
-	}(connection)
-
 	// start a go routine to start the twich channel listening to the server
-	go func() {
-		err := c.Listen()
-		ch <- err.Error()
-	}()
+	go c.Listen()
+	defer c.Shutdown(context.Background())
+
+	for server.Accepted() == 0 {
+		time.Sleep(time.Millisecond)
+	}
 
 	// simulate the twitch server's occasional ping command
-	connection.Write([]byte("PING :tmi.twitch.tv\n"))
+	server.Inject("PING :tmi.twitch.tv")
 
 	// wait to get the pong response from the channel sent to the server
-	result := <-ch
-	if result != "PONG :tmi.twitch.tv" {
-		t.Errorf("expected pong command got %s", result)
+	if _, err := server.WaitFor(timeout(t), equals("PONG :tmi.twitch.tv")); err != nil {
+		t.Errorf("expected pong command: %s", err)
 	}
 }
 
@@ -223,3 +203,249 @@ func TestAuthenticate(t *testing.T) {
 		t.Error("Expected JOIN to be sent")
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	digested := make(chan bool, 1)
+	slow := func(m birc.Message, w birc.ChannelWriter) {
+		time.Sleep(50 * time.Millisecond)
+		digested <- true
+	}
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, slow)
+	c.Config.Server = l.Addr().String()
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+
+	listening := make(chan error, 1)
+	go func() {
+		listening <- c.Listen()
+	}()
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-digested:
+	default:
+		t.Error("expected Shutdown to wait for running digesters")
+	}
+
+	for _, expected := range []string{"PART #test", "QUIT"} {
+		line, _, err := reader.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != expected {
+			t.Errorf("expected %s, got %s", expected, line)
+		}
+	}
+
+	if err := <-listening; err != nil {
+		t.Errorf("expected Listen to return nil after Shutdown, got %s", err)
+	}
+
+	if err := c.Send("too late"); err != birc.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestShutdownWithoutListener(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = server.Addr
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	messages := c.Messages(1, birc.OverflowDropNewest)
+	disconnected := make(chan error, 1)
+	c.OnDisconnect(func(c *birc.Channel, err error) { disconnected <- err })
+
+	// Hold a send so the drain outlasts the context.
+	writing, release := make(chan bool), make(chan bool)
+	defer close(release)
+	c.SetWriter(&Writer{Proxy: func(m *sirc.Message) {
+		if m.Command == sirc.PRIVMSG {
+			writing <- true
+			<-release
+		}
+	}})
+	go c.Send("hi")
+	<-writing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if c.State() != birc.StateClosed {
+		t.Errorf("expected closed, got %s", c.State())
+	}
+	select {
+	case err := <-disconnected:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected the disconnect hook to get the deadline, got %v", err)
+		}
+	default:
+		t.Error("expected the disconnect hook to be called")
+	}
+	if _, ok := <-messages; ok {
+		t.Error("expected the stream to be closed")
+	}
+	if err := c.Listen(); err != birc.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestReconnectWhileListening(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	digested := make(chan string, 1)
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, func(m birc.Message, w birc.ChannelWriter) {
+		if m.Command == "PRIVMSG" {
+			digested <- m.Content
+		}
+	})
+	c.Config.Server = server.Addr
+	joined := make(chan string, 1)
+	c.OnJoin(func(c *birc.Channel) { joined <- "join" })
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	listening := make(chan error, 1)
+	go func() { listening <- c.Listen() }()
+	expectHooks(t, joined, "join")
+
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	expectHooks(t, joined, "join")
+	if server.Accepted() != 2 {
+		t.Errorf("expected 2 connections, got %d", server.Accepted())
+	}
+
+	server.Chat("test", "bob", "still here", nil)
+	select {
+	case content := <-digested:
+		if content != "still here" {
+			t.Errorf("expected the message from the new connection, got %s", content)
+		}
+	case err := <-listening:
+		t.Fatalf("expected the listener to keep reading, it returned %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the message from the new connection")
+	}
+	if c.State() != birc.StateJoined || c.Stats().Errors != 0 {
+		t.Errorf("expected to be joined without errors, got %s and %+v", c.State(), c.Stats())
+	}
+
+	c.Shutdown(context.Background())
+	if err := <-listening; err != nil {
+		t.Errorf("expected Listen to return nil after Shutdown, got %s", err)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	listen := func() (*birc.Channel, chan error) {
+		c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+		c.Config.Server = server.Addr
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		listening := make(chan error, 1)
+		go func() { listening <- c.Listen() }()
+		return c, listening
+	}
+
+	// The PONG shows the listener is running.
+	c, listening := listen()
+	server.Inject("PING :tmi.twitch.tv")
+	if _, err := server.WaitFor(timeout(t), equals("PONG :tmi.twitch.tv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-listening; err != nil {
+		t.Errorf("expected Listen to return nil after Disconnect, got %s", err)
+	}
+	if err := c.Disconnect(); err != birc.ErrClosed {
+		t.Errorf("expected ErrClosed from a second Disconnect, got %v", err)
+	}
+
+	// A listener that already returned can't be disconnected either.
+	c, listening = listen()
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitFor(timeout(t), equals("JOIN #test")); err != nil {
+		t.Fatal(err)
+	}
+	server.Drop()
+	if err := <-listening; err == nil {
+		t.Error("expected Listen to fail when the server drops the connection")
+	}
+	if err := c.Disconnect(); err != birc.ErrClosed {
+		t.Errorf("expected ErrClosed after Listen returned, got %v", err)
+	}
+}
+
+// connected returns a listening channel connected to a local server, along with
+// the server's side of the connection.
+func connected(t *testing.T, digesters ...birc.Digester) (*birc.Channel, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, digesters...)
+	c.Config.Server = l.Addr().String()
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Listen()
+	return c, conn
+}
+
+// timeout returns a context that expires after a second.
+func timeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func equals(expected string) func(line string) bool {
+	return func(line string) bool { return line == expected }
+}
@@ -0,0 +1,161 @@
+package birc
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a Channel and the middleware run by it.
+// Replacing it with a FakeClock lets time dependent behaviour be tested
+// without sleeping.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call created by Clock.AfterFunc. *time.Timer implements it.
+type Timer interface {
+	// Stop prevents the call, reporting whether it was still pending.
+	Stop() bool
+	// Reset reschedules the call to happen after d, reporting whether it was
+	// still pending.
+	Reset(d time.Duration) bool
+}
+
+// ClockProvider is implemented by ChannelWriters that have a Clock. Middleware
+// uses the writer's clock, falling back to the real one.
+type ClockProvider interface {
+	Clock() Clock
+}
+
+type realClock struct{}
+
+// RealClock returns a Clock backed by the time package. This is the default.
+func RealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock sets the Clock used for timestamps and timeouts. It should be
+// called before Connect.
+func (c *Channel) SetClock(clock Clock) {
+	c.mu.Lock()
+	c.clock = clock
+	c.mu.Unlock()
+}
+
+// Clock returns the Channel's Clock.
+func (c *Channel) Clock() Clock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clock == nil {
+		return RealClock()
+	}
+	return c.clock
+}
+
+// clockOf returns w's Clock if it has one.
+func clockOf(w ChannelWriter) Clock {
+	if p, ok := w.(ClockProvider); ok {
+		return p.Clock()
+	}
+	return RealClock()
+}
+
+// FakeClock is a Clock that only moves when told to. Timers fire
+// synchronously from Advance, in the order they are due. It is safe for
+// concurrent use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake current time.
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// AfterFunc schedules f to be called once the clock has advanced by d.
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, calling every timer that becomes due.
+// The clock reads each timer's due time while its function runs.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		next := -1
+		for i, t := range f.timers {
+			if !t.at.After(end) && (next < 0 || t.at.Before(f.timers[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		t := f.timers[next]
+		f.timers = append(f.timers[:next], f.timers[next+1:]...)
+		if t.at.After(f.now) {
+			f.now = t.at
+		}
+		f.mu.Unlock()
+		t.fn()
+		f.mu.Lock()
+	}
+	f.now = end
+	f.mu.Unlock()
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.remove()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	pending := t.remove()
+	t.at = t.clock.now.Add(d)
+	t.clock.timers = append(t.clock.timers, t)
+	return pending
+}
+
+// remove unschedules the timer, reporting whether it was scheduled. The
+// clock's lock must be held.
+func (t *fakeTimer) remove() bool {
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package birc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 5, 13, 12, 0, 0, 0, time.UTC)
+	clock := birc.NewFakeClock(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() {
+		fired = append(fired, "second")
+		if !clock.Now().Equal(start.Add(2 * time.Second)) {
+			t.Errorf("expected the clock to read the due time, got %v", clock.Now())
+		}
+	})
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	reset := clock.AfterFunc(time.Second, func() { fired = append(fired, "reset") })
+
+	if !stopped.Stop() {
+		t.Error("expected Stop to report a pending timer")
+	}
+	if !reset.Reset(5 * time.Second) {
+		t.Error("expected Reset to report a pending timer")
+	}
+
+	clock.Advance(3 * time.Second)
+	if len(fired) != 2 || fired[0] != "first" || fired[1] != "second" {
+		t.Errorf("expected first and second to fire in order, got %v", fired)
+	}
+	if !clock.Now().Equal(start.Add(3 * time.Second)) {
+		t.Errorf("expected the clock to be advanced by 3s, got %v", clock.Now())
+	}
+
+	clock.Advance(2 * time.Second)
+	if len(fired) != 3 || fired[2] != "reset" {
+		t.Errorf("expected the reset timer to fire, got %v", fired)
+	}
+	if reset.Stop() {
+		t.Error("expected Stop to report a timer that already fired")
+	}
+}
+
+func TestChannelClock(t *testing.T) {
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	clock := birc.NewFakeClock(time.Date(2016, 5, 13, 12, 0, 0, 0, time.UTC))
+	c.SetClock(clock)
+
+	var elapsed time.Duration
+	d := birc.Chain(func(m birc.Message, w birc.ChannelWriter) {
+		clock.Advance(time.Minute)
+	}, birc.Timing(func(m birc.Message, d time.Duration) {
+		elapsed = d
+	}))
+	d(chat("bob", "hi"), c)
+
+	if elapsed != time.Minute {
+		t.Errorf("expected Timing to use the channel's clock, got %s", elapsed)
+	}
+}
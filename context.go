@@ -0,0 +1,140 @@
+package birc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextDigester is a Digester that receives a context. The context is
+// cancelled when the Channel disconnects or the handler's timeout expires, and
+// carries the channel name and a trace id for the message.
+type ContextDigester func(ctx context.Context, m Message, c ChannelWriter)
+
+// ContextProvider is implemented by ChannelWriters that have a lifetime.
+// Channel's context is cancelled when it shuts down or stops listening.
+type ContextProvider interface {
+	Context() context.Context
+}
+
+type contextKey int
+
+const (
+	channelKey contextKey = iota
+	traceKey
+)
+
+// Contextual adapts a ContextDigester into a Digester. Each message gets its own
+// context derived from the ChannelWriter's if it is a ContextProvider. A
+// timeout of zero or less means the handler has no deadline of its own. The
+// timeout is measured with the ChannelWriter's Clock.
+func Contextual(d ContextDigester, timeout time.Duration) Digester {
+	return func(m Message, c ChannelWriter) {
+		ctx := context.Background()
+		if p, ok := c.(ContextProvider); ok {
+			ctx = p.Context()
+		}
+
+		channel := strings.TrimPrefix(ByChannel(m), "#")
+		if channel == "" {
+			channel = c.GetConfig().ChannelName
+		}
+		ctx = context.WithValue(ctx, channelKey, channel)
+		ctx = context.WithValue(ctx, traceKey, traceID(m))
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, clockOf(c), timeout)
+			defer cancel()
+		}
+		d(ctx, m, c)
+	}
+}
+
+// withTimeout is context.WithTimeout measured with clock.
+func withTimeout(parent context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := clock.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return &deadlineContext{Context: ctx, deadline: clock.Now().Add(timeout)}, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// deadlineContext reports the deadline of a context cancelled by a Clock's
+// timer, and DeadlineExceeded once it has passed.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *deadlineContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// ChannelFromContext returns the name of the channel the message was sent to.
+func ChannelFromContext(ctx context.Context) string {
+	channel, _ := ctx.Value(channelKey).(string)
+	return channel
+}
+
+// TraceIDFromContext returns the message's trace id, which is Twitch's message
+// id when it has one.
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey).(string)
+	return id
+}
+
+func traceID(m Message) string {
+	if id := m.Tags["id"]; id != "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type lifetime struct {
+	sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Context returns a context that is cancelled when the Channel shuts down or
+// stops listening. Connecting again starts a new one.
+func (c *Channel) Context() context.Context {
+	c.lifetime.Lock()
+	defer c.lifetime.Unlock()
+	if c.lifetime.ctx == nil {
+		c.lifetime.ctx, c.lifetime.cancel = context.WithCancel(context.Background())
+	}
+	return c.lifetime.ctx
+}
+
+func (c *Channel) cancelContext() {
+	c.lifetime.Lock()
+	defer c.lifetime.Unlock()
+	if c.lifetime.cancel != nil {
+		c.lifetime.cancel()
+	}
+}
+
+// renewContext replaces a cancelled context when the Channel connects again.
+func (c *Channel) renewContext() {
+	c.lifetime.Lock()
+	defer c.lifetime.Unlock()
+	if c.lifetime.ctx != nil && c.lifetime.ctx.Err() != nil {
+		c.lifetime.ctx, c.lifetime.cancel = nil, nil
+	}
+}
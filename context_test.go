@@ -0,0 +1,79 @@
+package birc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestContextualValuesAndTimeout(t *testing.T) {
+	var channel, trace string
+	var deadline bool
+	d := birc.Contextual(func(ctx context.Context, m birc.Message, w birc.ChannelWriter) {
+		channel, trace = birc.ChannelFromContext(ctx), birc.TraceIDFromContext(ctx)
+		_, deadline = ctx.Deadline()
+	}, time.Second)
+
+	var w replies
+	d(parse(t, "@id=abc-123 :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi"), &w)
+
+	if channel != "test" || trace != "abc-123" || !deadline {
+		t.Errorf("unexpected context values %q %q %t", channel, trace, deadline)
+	}
+}
+
+func TestContextualTimeoutUsesClock(t *testing.T) {
+	start := time.Date(2017, 10, 5, 12, 0, 0, 0, time.UTC)
+	clock := birc.NewFakeClock(start)
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.SetClock(clock)
+
+	started := make(chan time.Time)
+	expired := make(chan error, 1)
+	d := birc.Contextual(func(ctx context.Context, m birc.Message, w birc.ChannelWriter) {
+		deadline, _ := ctx.Deadline()
+		started <- deadline
+		<-ctx.Done()
+		expired <- ctx.Err()
+	}, time.Minute)
+	go d(parse(t, ":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi"), c)
+
+	if deadline := <-started; !deadline.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected the deadline to be a minute from the clock's time, got %s", deadline)
+	}
+	clock.Advance(time.Minute - time.Second)
+	select {
+	case err := <-expired:
+		t.Fatalf("expected the context to outlive %s, got %v", time.Minute-time.Second, err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-expired; err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestContextualCancelledOnShutdown(t *testing.T) {
+	started := make(chan bool)
+	cancelled := make(chan error, 1)
+	c, conn := connected(t, birc.Contextual(func(ctx context.Context, m birc.Message, w birc.ChannelWriter) {
+		started <- true
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+	}, 0))
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("expected the digester's context to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package birc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	sirc "github.com/sorcix/irc"
+)
+
+// Cooldown limits how often something can be triggered from chat. Each window
+// that is set applies independently: User per sender, Channel per channel and
+// Global across everything using the Cooldown. A Cooldown is safe to share
+// between goroutines but should not be copied after first use.
+type Cooldown struct {
+	User    time.Duration
+	Channel time.Duration
+	Global  time.Duration
+	// ExemptModerators lets moderators and the broadcaster skip the cooldown.
+	ExemptModerators bool
+	// Whisper tells users how long they have to wait when they hit the
+	// cooldown, once until it expires.
+	Whisper bool
+	// Clock is the source of time. Defaults to the Clock of the ChannelWriter
+	// passed to the Router or middleware, and the real clock for Allow.
+	Clock Clock
+
+	mu       sync.Mutex
+	last     map[string]time.Time
+	whispers map[string]time.Time
+}
+
+// Allow reports whether m may pass and records it if so. When it may not, it
+// returns how long remains until it would.
+func (cd *Cooldown) Allow(m Message) (bool, time.Duration) {
+	clock := cd.Clock
+	if clock == nil {
+		clock = RealClock()
+	}
+	return cd.allow(m, clock.Now())
+}
+
+func (cd *Cooldown) allow(m Message, now time.Time) (bool, time.Duration) {
+	if cd.ExemptModerators && PermissionOf(m) >= Moderator {
+		return true, 0
+	}
+
+	windows := map[string]time.Duration{
+		"user:" + ByUser(m):       cd.User,
+		"channel:" + ByChannel(m): cd.Channel,
+		"global":                  cd.Global,
+	}
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if cd.last == nil {
+		cd.last = map[string]time.Time{}
+	}
+
+	var remaining time.Duration
+	for key, window := range windows {
+		if window <= 0 {
+			continue
+		}
+		if r := cd.last[key].Add(window).Sub(now); r > remaining {
+			remaining = r
+		}
+	}
+	if remaining > 0 {
+		return false, remaining
+	}
+
+	cd.prune(now)
+	for key, window := range windows {
+		if window > 0 {
+			cd.last[key] = now
+		}
+	}
+	return true, 0
+}
+
+// prune forgets users whose cooldown has expired.
+func (cd *Cooldown) prune(now time.Time) {
+	for key, t := range cd.last {
+		if now.Sub(t) >= cd.User && now.Sub(t) >= cd.Channel && now.Sub(t) >= cd.Global {
+			delete(cd.last, key)
+		}
+	}
+	for user, until := range cd.whispers {
+		if !now.Before(until) {
+			delete(cd.whispers, user)
+		}
+	}
+}
+
+// whisper reports whether user should be told to wait, which is only the first
+// time they hit the cooldown until it expires.
+func (cd *Cooldown) whisper(user string, now time.Time, remaining time.Duration) bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if now.Before(cd.whispers[user]) {
+		return false
+	}
+	if cd.whispers == nil {
+		cd.whispers = map[string]time.Time{}
+	}
+	cd.whispers[user] = now.Add(remaining)
+	return true
+}
+
+// check applies the cooldown to m, whispering the sender the first time they
+// have to wait.
+func (cd *Cooldown) check(m Message, c ChannelWriter) bool {
+	clock := cd.Clock
+	if clock == nil {
+		clock = clockOf(c)
+	}
+	now := clock.Now()
+	ok, remaining := cd.allow(m, now)
+	if !ok && cd.Whisper && ByUser(m) != "" && cd.whisper(ByUser(m), now, remaining) {
+		c.SendMessage(&Message{
+			Command: sirc.PRIVMSG,
+			Params:  []string{"#" + c.GetConfig().ChannelName},
+			Content: fmt.Sprintf("/w %s Please wait %s before using that again.", ByUser(m), remaining.Round(time.Second)),
+			Trusted: true,
+		})
+	}
+	return ok
+}
+
+// Middleware returns middleware that drops messages while the cooldown applies.
+func (cd *Cooldown) Middleware() Middleware {
+	return func(d Digester) Digester {
+		return func(m Message, c ChannelWriter) {
+			if cd.check(m, c) {
+				d(m, c)
+			}
+		}
+	}
+}
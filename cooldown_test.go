@@ -0,0 +1,94 @@
+package birc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestCooldownWindows(t *testing.T) {
+	clock := birc.NewFakeClock(time.Date(2016, 5, 13, 12, 0, 0, 0, time.UTC))
+	cd := &birc.Cooldown{
+		User:   30 * time.Second,
+		Global: 5 * time.Second,
+		Clock:  clock,
+	}
+
+	if ok, _ := cd.Allow(chat("bob", "!cmd")); !ok {
+		t.Fatal("expected the first use to pass")
+	}
+
+	if ok, remaining := cd.Allow(chat("alice", "!cmd")); ok || remaining != 5*time.Second {
+		t.Errorf("expected the global cooldown to apply with 5s remaining, got %t %s", ok, remaining)
+	}
+
+	clock.Advance(5 * time.Second)
+	if ok, remaining := cd.Allow(chat("bob", "!cmd")); ok || remaining != 25*time.Second {
+		t.Errorf("expected the user cooldown to apply with 25s remaining, got %t %s", ok, remaining)
+	}
+	if ok, _ := cd.Allow(chat("alice", "!cmd")); !ok {
+		t.Error("expected another user to pass once the global cooldown expired")
+	}
+
+	clock.Advance(25 * time.Second)
+	if ok, _ := cd.Allow(chat("bob", "!cmd")); !ok {
+		t.Error("expected the user cooldown to expire")
+	}
+}
+
+func TestCooldownExemptsModerators(t *testing.T) {
+	cd := &birc.Cooldown{Global: time.Minute, ExemptModerators: true}
+
+	cd.Allow(chat("bob", "!cmd"))
+	if ok, _ := cd.Allow(privmsg(t, "moderator/1", "!cmd")); !ok {
+		t.Error("expected moderators to skip the cooldown")
+	}
+}
+
+func TestCooldownWhispersRouterUsers(t *testing.T) {
+	r := birc.NewRouter("!")
+	var calls int
+	r.Handle(birc.Command{
+		Name:     "dice",
+		Cooldown: &birc.Cooldown{User: time.Minute, Whisper: true},
+		Handler:  func(birc.Message, birc.Args, birc.ChannelWriter) { calls++ },
+	})
+
+	var w replies
+	r.Digest(privmsg(t, "", "!dice"), &w)
+	r.Digest(privmsg(t, "", "!dice"), &w)
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	if len(w) != 1 || w[0] != "/w bob Please wait 1m0s before using that again." {
+		t.Errorf("expected a whisper, got %v", w)
+	}
+}
+
+func TestCooldownWhispersOncePerWindow(t *testing.T) {
+	clock := birc.NewFakeClock(time.Date(2016, 5, 13, 12, 0, 0, 0, time.UTC))
+	r := birc.NewRouter("!")
+	r.Handle(birc.Command{
+		Name:     "dice",
+		Cooldown: &birc.Cooldown{User: time.Minute, Whisper: true, Clock: clock},
+		Handler:  func(birc.Message, birc.Args, birc.ChannelWriter) {},
+	})
+
+	var w replies
+	for i := 0; i < 4; i++ {
+		r.Digest(privmsg(t, "", "!dice"), &w)
+		clock.Advance(10 * time.Second)
+	}
+	if len(w) != 1 || w[0] != "/w bob Please wait 50s before using that again." {
+		t.Errorf("expected a single whisper, got %v", w)
+	}
+
+	clock.Advance(30 * time.Second)
+	r.Digest(privmsg(t, "", "!dice"), &w)
+	r.Digest(privmsg(t, "", "!dice"), &w)
+	if len(w) != 2 {
+		t.Errorf("expected another whisper once the cooldown expired, got %v", w)
+	}
+}
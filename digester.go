@@ -1,29 +1,104 @@
 package birc
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"time"
 )
 
-const timeFormat = "2006-01-06 15:04:05"
+const timeFormat = "2006-01-02 15:04:05"
 
 // Digester is a handler function for parsing and reacting to IRC chat messages.
 // All digesters MUST be thread safe, they will be called from multiple go routines.
 type Digester func(m Message, c ChannelWriter)
 
-// Logger is a digester that simply echoes out user's messages to stdout.
+// Logger is a digester that simply echoes out user's messages to stdout. Messages
+// are stamped with the time Twitch sent them when it is known.
 func Logger(m Message, c ChannelWriter) {
-	if m.Username != "" && m.Content != "" {
-		fmt.Printf("\n%s %s: %s", m.Time.Format(timeFormat), m.Username, m.Content)
+	if line, ok := logLine(m); ok {
+		fmt.Printf("\n%s", line)
 	}
 }
 
 // CustomLogger will write the incoming messages to the supplied io.Writer.
 func CustomLogger(w io.Writer) Digester {
 	return func(m Message, c ChannelWriter) {
-		if m.Username != "" && m.Content != "" {
-			o := fmt.Sprintf("\n%s %s: %s", m.Time.Format(timeFormat), m.Username, m.Content)
-			w.Write([]byte(o))
+		if line, ok := logLine(m); ok {
+			w.Write([]byte("\n" + line))
 		}
 	}
 }
+
+// logLine formats a user's message for the loggers, without a line ending. It
+// reports false for messages that aren't logged.
+func logLine(m Message) (string, bool) {
+	if m.Username == "" || m.Content == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s %s: %s", m.Timestamp().Format(timeFormat), m.Username, m.Content), true
+}
+
+// JSONLogger returns a digester that writes every message to w as a JSON object
+// on its own line. Each line is written with a single call to w, so lines from
+// concurrent digesters never interleave. Write errors are reported to the
+// ChannelWriter if it is an ErrorReporter.
+//
+// fields selects what is logged, out of time, server_time, channel, name,
+// username, host, command, params, content and tags. A single tag can be
+// selected as tags.<name>, e.g. tags.display-name. Without fields everything
+// is logged. Empty values are left out.
+func JSONLogger(w io.Writer, fields ...string) Digester {
+	all := len(fields) == 0
+	selected := map[string]bool{}
+	var tags []string
+	for _, f := range fields {
+		if strings.HasPrefix(f, "tags.") {
+			tags = append(tags, strings.TrimPrefix(f, "tags."))
+		} else {
+			selected[f] = true
+		}
+	}
+
+	var mu sync.Mutex
+	return Errors(func(m Message, c ChannelWriter) error {
+		entry := map[string]interface{}{}
+		add := func(field string, v interface{}, empty bool) {
+			if (all || selected[field]) && !empty {
+				entry[field] = v
+			}
+		}
+		add("time", m.Time.Format(time.RFC3339Nano), m.Time.IsZero())
+		add("server_time", m.ServerTime.Format(time.RFC3339Nano), m.ServerTime.IsZero())
+		add("channel", ByChannel(m), ByChannel(m) == "")
+		add("name", m.Name, m.Name == "")
+		add("username", m.Username, m.Username == "")
+		add("host", m.Host, m.Host == "")
+		add("command", m.Command, m.Command == "")
+		add("params", m.Params, len(m.Params) == 0)
+		add("content", m.Content, m.Content == "")
+		add("tags", m.Tags, len(m.Tags) == 0)
+		if !all && !selected["tags"] && len(tags) > 0 {
+			picked := map[string]string{}
+			for _, t := range tags {
+				if v, ok := m.Tags[t]; ok {
+					picked[t] = v
+				}
+			}
+			if len(picked) > 0 {
+				entry["tags"] = picked
+			}
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = w.Write(append(line, '\n'))
+		return err
+	})
+}
@@ -1,10 +1,17 @@
 package birc_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
 )
 
 func TestCustomLoggerIsDigester(t *testing.T) {
@@ -13,3 +20,78 @@ func TestCustomLoggerIsDigester(t *testing.T) {
 		t.Error(fmt.Errorf("CustomLogger does not implement Digester"))
 	}
 }
+
+func TestCustomLoggerUsesServerTime(t *testing.T) {
+	var b bytes.Buffer
+	m := birc.Message{
+		Username:   "bob",
+		Content:    "hi",
+		Time:       time.Date(2020, 1, 1, 0, 0, 5, 0, time.Local),
+		ServerTime: time.Date(2020, 1, 1, 0, 0, 1, 0, time.Local),
+	}
+	birc.CustomLogger(&b)(m, nil)
+
+	if !strings.Contains(b.String(), "2020-01-01 00:00:01 bob: hi") {
+		t.Errorf("expected the server time to be logged, got %q", b.String())
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	m := birc.ParseMessage("@display-name=Bob;tmi-sent-ts=1507246572675 :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi there")
+	m.Time = time.Date(2017, 10, 5, 23, 36, 13, 0, time.UTC)
+
+	var b bytes.Buffer
+	birc.JSONLogger(&b)(*m, nil)
+	expected := `{"channel":"#test","command":"PRIVMSG","content":"hi there","host":"bob.tmi.twitch.tv","name":"bob",` +
+		`"params":["#test"],"server_time":"` + m.ServerTime.Format(time.RFC3339Nano) + `",` +
+		`"tags":{"display-name":"Bob","tmi-sent-ts":"1507246572675"},"time":"2017-10-05T23:36:13Z","username":"bob"}` + "\n"
+	if b.String() != expected {
+		t.Errorf("expected %s, got %s", expected, b.String())
+	}
+
+	b.Reset()
+	birc.JSONLogger(&b, "username", "content", "tags.display-name", "tags.missing")(*m, nil)
+	if expected := `{"content":"hi there","tags":{"display-name":"Bob"},"username":"bob"}` + "\n"; b.String() != expected {
+		t.Errorf("expected %s, got %s", expected, b.String())
+	}
+}
+
+func TestJSONLoggerConcurrentWrites(t *testing.T) {
+	var b bytes.Buffer
+	logger := birc.JSONLogger(&b, "content")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger(birc.Message{Content: strings.Repeat("x", i*100)}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 50 {
+		t.Fatalf("expected 50 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]string
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("expected a JSON object, got %q: %s", line, err)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestJSONLoggerReportsErrors(t *testing.T) {
+	r := birctest.NewRecorder("test", "foobar")
+	birc.JSONLogger(failingWriter{})(birc.Message{Content: "hi"}, r)
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "disk full" {
+		t.Errorf("expected the write error to be reported, got %v", errs)
+	}
+}
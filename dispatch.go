@@ -0,0 +1,291 @@
+package birc
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// Dispatcher decides how decoded messages are delivered to digesters.
+type Dispatcher interface {
+	// Dispatch delivers m to every digester. It is called from the listener's
+	// goroutine, so blocking here stops the Channel from reading.
+	Dispatch(m Message, w ChannelWriter, digesters []Digester)
+	// Wait blocks until every dispatched message has been digested.
+	Wait()
+	// Stats returns the dispatcher's queue metrics.
+	Stats() DispatchStats
+}
+
+// DispatchStats contains a Dispatcher's queue metrics.
+type DispatchStats struct {
+	// Queued is the number of messages waiting to be digested.
+	Queued int
+	// MaxQueued is the highest value Queued has reached.
+	MaxQueued int
+	// Dropped is the number of messages discarded by the overflow policy.
+	Dropped uint64
+}
+
+// OverflowPolicy decides what happens to a message when a queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the queue has room.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the incoming message.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued message to make room.
+	OverflowDropOldest
+)
+
+// KeyFunc maps a message to the key used to order and distribute it.
+type KeyFunc func(m Message) string
+
+// ByChannel keys messages by the IRC channel they were sent to.
+func ByChannel(m Message) string {
+	if len(m.Params) > 0 {
+		return m.Params[0]
+	}
+	return ""
+}
+
+// ByUser keys messages by the user who sent them.
+func ByUser(m Message) string {
+	if m.Username != "" {
+		return m.Username
+	}
+	return m.Name
+}
+
+// SetDispatcher sets the strategy used to deliver messages to digesters.
+// It should be called before Listen.
+func (c *Channel) SetDispatcher(d Dispatcher) {
+	c.mu.Lock()
+	c.dispatcher = d
+	c.mu.Unlock()
+}
+
+// DispatchStats returns the queue metrics of the Channel's Dispatcher.
+func (c *Channel) DispatchStats() DispatchStats {
+	return c.getDispatcher().Stats()
+}
+
+func (c *Channel) getDispatcher() Dispatcher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dispatcher == nil {
+		c.dispatcher = FireAndForget()
+	}
+	return c.dispatcher
+}
+
+// inflight counts dispatched messages that haven't been digested yet.
+type inflight struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	n    int
+}
+
+// add records a dispatched message and returns the number in flight.
+func (f *inflight) add() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+	return f.n
+}
+
+func (f *inflight) done() {
+	f.mu.Lock()
+	f.n--
+	if f.n == 0 && f.cond != nil {
+		f.cond.Broadcast()
+	}
+	f.mu.Unlock()
+}
+
+func (f *inflight) wait() {
+	f.mu.Lock()
+	if f.cond == nil {
+		f.cond = sync.NewCond(&f.mu)
+	}
+	for f.n > 0 {
+		f.cond.Wait()
+	}
+	f.mu.Unlock()
+}
+
+func (f *inflight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+type fireAndForget struct {
+	inflight inflight
+	mu       sync.Mutex
+	max      int
+}
+
+// FireAndForget returns a Dispatcher that runs every digester in its own goroutine.
+// Messages are not ordered and the number of goroutines is unbounded. Queued reports
+// the number of running digesters. This is the default.
+func FireAndForget() Dispatcher {
+	return &fireAndForget{}
+}
+
+func (d *fireAndForget) Dispatch(m Message, w ChannelWriter, digesters []Digester) {
+	for _, dg := range digesters {
+		n := d.inflight.add()
+		d.mu.Lock()
+		if n > d.max {
+			d.max = n
+		}
+		d.mu.Unlock()
+		go func(dg Digester) {
+			defer d.inflight.done()
+			dg(m, w)
+		}(dg)
+	}
+}
+
+func (d *fireAndForget) Wait() {
+	d.inflight.wait()
+}
+
+func (d *fireAndForget) Stats() DispatchStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DispatchStats{Queued: d.inflight.count(), MaxQueued: d.max}
+}
+
+type job struct {
+	m         Message
+	w         ChannelWriter
+	digesters []Digester
+}
+
+type lane struct {
+	queue   []job
+	running bool
+}
+
+// keyed queues messages into lanes. Each lane is drained in order by a single
+// goroutine that exits once the lane is empty.
+type keyed struct {
+	mu       sync.Mutex
+	space    *sync.Cond
+	lanes    map[string]*lane
+	key      KeyFunc
+	size     int
+	overflow OverflowPolicy
+	stats    DispatchStats
+	inflight inflight
+}
+
+func newKeyed(key KeyFunc, size int, overflow OverflowPolicy) *keyed {
+	d := &keyed{lanes: map[string]*lane{}, key: key, size: size, overflow: overflow}
+	d.space = sync.NewCond(&d.mu)
+	return d
+}
+
+// Ordered returns a Dispatcher that digests messages strictly in the order they
+// were received, one at a time per channel. Each channel queues up to queueSize
+// messages; a queueSize of zero or less means unbounded.
+func Ordered(queueSize int, overflow OverflowPolicy) Dispatcher {
+	return newKeyed(ByChannel, queueSize, overflow)
+}
+
+// WorkerPool returns a Dispatcher with a fixed number of workers. Messages with
+// the same key always go to the same worker, so they are digested in order.
+// Each worker queues up to queueSize messages; a queueSize of zero or less means
+// unbounded.
+func WorkerPool(workers, queueSize int, key KeyFunc, overflow OverflowPolicy) Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	return newKeyed(func(m Message) string {
+		h := fnv.New32a()
+		h.Write([]byte(key(m)))
+		return strconv.Itoa(int(h.Sum32() % uint32(workers)))
+	}, queueSize, overflow)
+}
+
+func (d *keyed) Dispatch(m Message, w ChannelWriter, digesters []Digester) {
+	k := d.key(m)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var l *lane
+	for {
+		// The lane may be drained and removed while waiting for space, so it
+		// has to be looked up again on every pass.
+		var ok bool
+		if l, ok = d.lanes[k]; !ok {
+			l = &lane{}
+			d.lanes[k] = l
+		}
+		if d.size <= 0 || len(l.queue) < d.size {
+			break
+		}
+
+		switch d.overflow {
+		case OverflowDropNewest:
+			d.stats.Dropped++
+			return
+		case OverflowDropOldest:
+			l.queue = l.queue[1:]
+			d.stats.Queued--
+			d.stats.Dropped++
+			d.inflight.done()
+		default:
+			d.space.Wait()
+		}
+	}
+
+	l.queue = append(l.queue, job{m, w, digesters})
+	d.inflight.add()
+	d.stats.Queued++
+	if d.stats.Queued > d.stats.MaxQueued {
+		d.stats.MaxQueued = d.stats.Queued
+	}
+
+	if !l.running {
+		l.running = true
+		go d.drain(k, l)
+	}
+}
+
+func (d *keyed) drain(k string, l *lane) {
+	d.mu.Lock()
+	for len(l.queue) > 0 {
+		j := l.queue[0]
+		l.queue = l.queue[1:]
+		d.stats.Queued--
+		d.space.Broadcast()
+		d.mu.Unlock()
+
+		for _, dg := range j.digesters {
+			dg(j.m, j.w)
+		}
+		d.inflight.done()
+
+		d.mu.Lock()
+	}
+	l.running = false
+	if d.lanes[k] == l {
+		delete(d.lanes, k)
+	}
+	d.mu.Unlock()
+}
+
+func (d *keyed) Wait() {
+	d.inflight.wait()
+}
+
+func (d *keyed) Stats() DispatchStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
@@ -0,0 +1,128 @@
+package birc_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func chat(user, content string) birc.Message {
+	return birc.Message{
+		Username: user,
+		Content:  content,
+		Command:  "PRIVMSG",
+		Params:   []string{"#test"},
+	}
+}
+
+func TestOrderedDispatch(t *testing.T) {
+	d := birc.Ordered(0, birc.OverflowBlock)
+
+	var mu sync.Mutex
+	var got []string
+	record := func(m birc.Message, w birc.ChannelWriter) {
+		mu.Lock()
+		got = append(got, m.Content)
+		mu.Unlock()
+	}
+
+	for i := 0; i < 100; i++ {
+		d.Dispatch(chat("bob", strconv.Itoa(i)), nil, []birc.Digester{record})
+	}
+	d.Wait()
+
+	if len(got) != 100 {
+		t.Fatalf("expected 100 messages, got %d", len(got))
+	}
+	for i, content := range got {
+		if content != strconv.Itoa(i) {
+			t.Fatalf("expected message %d, got %s", i, content)
+		}
+	}
+
+	if stats := d.Stats(); stats.Queued != 0 || stats.MaxQueued < 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDispatchOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy   birc.OverflowPolicy
+		expected []string
+	}{
+		{birc.OverflowDropNewest, []string{"0", "1", "2"}},
+		{birc.OverflowDropOldest, []string{"0", "3", "4"}},
+	} {
+		d := birc.Ordered(2, tc.policy)
+
+		started, block := make(chan bool), make(chan bool)
+		var got []string
+		record := func(m birc.Message, w birc.ChannelWriter) {
+			if m.Content == "0" {
+				started <- true
+				<-block
+			}
+			got = append(got, m.Content)
+		}
+
+		// Hold the first message in the digester so the rest pile up in the queue.
+		d.Dispatch(chat("bob", "0"), nil, []birc.Digester{record})
+		<-started
+		for i := 1; i < 5; i++ {
+			d.Dispatch(chat("bob", strconv.Itoa(i)), nil, []birc.Digester{record})
+		}
+		close(block)
+		d.Wait()
+
+		if len(got) != len(tc.expected) {
+			t.Fatalf("expected %v, got %v", tc.expected, got)
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		}
+		if stats := d.Stats(); stats.Dropped != 2 || stats.MaxQueued != 2 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+
+		c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+		c.SetDispatcher(d)
+		if stats := c.DispatchStats(); stats != d.Stats() {
+			t.Errorf("expected the Channel to report its dispatcher's stats, got %+v", stats)
+		}
+	}
+}
+
+func TestWorkerPoolKeepsUserOrder(t *testing.T) {
+	d := birc.WorkerPool(4, 8, birc.ByUser, birc.OverflowBlock)
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	record := func(m birc.Message, w birc.ChannelWriter) {
+		mu.Lock()
+		got[m.Username] = append(got[m.Username], m.Content)
+		mu.Unlock()
+	}
+
+	users := []string{"alice", "bob", "carol", "dave", "eve"}
+	for i := 0; i < 50; i++ {
+		for _, u := range users {
+			d.Dispatch(chat(u, strconv.Itoa(i)), nil, []birc.Digester{record})
+		}
+	}
+	d.Wait()
+
+	for _, u := range users {
+		if len(got[u]) != 50 {
+			t.Fatalf("expected 50 messages for %s, got %d", u, len(got[u]))
+		}
+		for i, content := range got[u] {
+			if content != strconv.Itoa(i) {
+				t.Fatalf("expected message %d for %s, got %s", i, u, content)
+			}
+		}
+	}
+}
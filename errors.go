@@ -0,0 +1,113 @@
+package birc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotConnected is returned when using a Channel that hasn't connected.
+	ErrNotConnected = errors.New("birc: not connected")
+	// ErrClosed is returned when using a Channel that has been shut down.
+	ErrClosed = errors.New("birc: channel closed")
+	// ErrAuthFailed matches errors caused by the server rejecting the credentials.
+	ErrAuthFailed = errors.New("birc: authentication failed")
+	// ErrRateLimited matches errors caused by sending too many messages.
+	ErrRateLimited = errors.New("birc: rate limited")
+	// ErrMessageTooLong is returned when sending more than MaxMessageLength
+	// characters, or a message whose encoded line exceeds MaxLineLength bytes.
+	ErrMessageTooLong = errors.New("birc: message too long")
+)
+
+// NetError is a failure of the underlying connection.
+type NetError struct {
+	// Op is the operation that failed: dial, read or write.
+	Op  string
+	Err error
+}
+
+func (e *NetError) Error() string {
+	return fmt.Sprintf("birc: %s: %v", e.Op, e.Err)
+}
+
+func (e *NetError) Unwrap() error {
+	return e.Err
+}
+
+// NoticeError is a failure the server reported with a NOTICE. It matches
+// ErrAuthFailed and ErrRateLimited with errors.Is where appropriate.
+type NoticeError struct {
+	// ID is the notice's msg-id tag, which Twitch omits for login failures.
+	ID   string
+	Text string
+}
+
+func (e *NoticeError) Error() string {
+	if e.ID == "" {
+		return "birc: notice: " + e.Text
+	}
+	return fmt.Sprintf("birc: notice %s: %s", e.ID, e.Text)
+}
+
+// Is reports whether the notice is an authentication failure or rate limit.
+func (e *NoticeError) Is(target error) bool {
+	switch target {
+	case ErrAuthFailed:
+		return isAuthFailure(e.Text)
+	case ErrRateLimited:
+		return e.ID == "msg_ratelimit" || e.ID == "msg_duplicate"
+	}
+	return false
+}
+
+// isAuthFailure recognises the notices Twitch sends when it rejects PASS or NICK.
+func isAuthFailure(text string) bool {
+	return strings.HasPrefix(text, "Login authentication failed") ||
+		strings.HasPrefix(text, "Improperly formatted auth") ||
+		strings.HasPrefix(text, "Login unsuccessful")
+}
+
+// ErrorDigester is a Digester that reports failures by returning an error.
+type ErrorDigester func(m Message, c ChannelWriter) error
+
+// ErrorHandler receives errors together with the message that triggered them.
+// Like digesters it is called from multiple goroutines and must be thread safe.
+type ErrorHandler func(err error, m Message)
+
+// ErrorReporter is implemented by ChannelWriters that accept errors from
+// digesters. Channel implements it by passing errors to its ErrorHandler.
+type ErrorReporter interface {
+	ReportError(err error, m Message)
+}
+
+// Errors adapts an ErrorDigester into a Digester. Returned errors are reported
+// to the ChannelWriter if it is an ErrorReporter and dropped otherwise.
+func Errors(d ErrorDigester) Digester {
+	return func(m Message, c ChannelWriter) {
+		if err := d(m, c); err != nil {
+			if r, ok := c.(ErrorReporter); ok {
+				r.ReportError(err, m)
+			}
+		}
+	}
+}
+
+// SetErrorHandler sets the handler that receives errors reported to the Channel.
+func (c *Channel) SetErrorHandler(h ErrorHandler) {
+	c.mu.Lock()
+	c.errorHandler = h
+	c.mu.Unlock()
+}
+
+// ReportError passes err and the message that caused it to the Channel's
+// ErrorHandler. Without a handler the error is dropped.
+func (c *Channel) ReportError(err error, m Message) {
+	c.mu.Lock()
+	h := c.errorHandler
+	c.mu.Unlock()
+
+	if h != nil && err != nil {
+		h(err, m)
+	}
+}
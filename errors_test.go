@@ -0,0 +1,142 @@
+package birc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	sirc "github.com/sorcix/irc"
+)
+
+func TestErrorHandler(t *testing.T) {
+	failure := errors.New("lookup failed")
+	c, conn := connected(t, birc.Errors(func(m birc.Message, w birc.ChannelWriter) error {
+		if m.Content == "!fail" {
+			return failure
+		}
+		return nil
+	}))
+	defer c.Shutdown(context.Background())
+
+	type report struct {
+		err error
+		m   birc.Message
+	}
+	reports := make(chan report, 2)
+	c.SetErrorHandler(func(err error, m birc.Message) {
+		reports <- report{err, m}
+	})
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :!fail\r\n"))
+
+	select {
+	case r := <-reports:
+		if r.err != failure || r.m.Content != "!fail" {
+			t.Errorf("unexpected report %v for %q", r.err, r.m.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the error to be reported")
+	}
+}
+
+func TestErrorsWithoutReporter(t *testing.T) {
+	var w replies
+	d := birc.Errors(func(m birc.Message, w birc.ChannelWriter) error {
+		return errors.New("dropped")
+	})
+	d(chat("bob", "hi"), &w)
+}
+
+func TestNotConnected(t *testing.T) {
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+
+	if err := c.Send("hi"); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected from Send, got %v", err)
+	}
+	if err := c.Authenticate(); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected from Authenticate, got %v", err)
+	}
+	if err := c.Listen(); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected from Listen, got %v", err)
+	}
+	if err := c.Disconnect(); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected from Disconnect, got %v", err)
+	}
+	if err := c.Shutdown(context.Background()); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected from Shutdown, got %v", err)
+	}
+	if err := c.Send("hi"); !errors.Is(err, birc.ErrNotConnected) {
+		t.Errorf("expected Shutdown to leave the Channel open, got %v", err)
+	}
+}
+
+func TestMessageTooLong(t *testing.T) {
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.SetWriter(&Writer{func(m *sirc.Message) {}})
+
+	// ":foobar!foobar PRIVMSG #test :" leaves 480 bytes for the content.
+	if err := c.Send(strings.Repeat("é", 240)); err != nil {
+		t.Errorf("expected a line of %d bytes to be accepted, got %v", birc.MaxLineLength, err)
+	}
+	if err := c.Send(strings.Repeat("é", 241)); !errors.Is(err, birc.ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong for a line over %d bytes, got %v", birc.MaxLineLength, err)
+	}
+	if err := c.Send(strings.Repeat("a", birc.MaxMessageLength+1)); !errors.Is(err, birc.ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong, got %v", err)
+	}
+}
+
+func TestDialError(t *testing.T) {
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = "127.0.0.1:0"
+
+	var netErr *birc.NetError
+	if err := c.Connect(); !errors.As(err, &netErr) || netErr.Op != "dial" {
+		t.Errorf("expected a dial NetError, got %v", err)
+	}
+}
+
+func TestAuthFailed(t *testing.T) {
+	c, conn := connected(t)
+	listening := make(chan error, 1)
+	c.OnDisconnect(func(c *birc.Channel, err error) {
+		listening <- err
+	})
+
+	conn.Write([]byte(":tmi.twitch.tv NOTICE * :Login authentication failed\r\n"))
+
+	select {
+	case err := <-listening:
+		if !errors.Is(err, birc.ErrAuthFailed) {
+			t.Errorf("expected ErrAuthFailed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the listener to stop")
+	}
+}
+
+func TestRateLimitedIsReported(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	reported := make(chan error, 1)
+	c.SetErrorHandler(func(err error, m birc.Message) {
+		reported <- err
+	})
+
+	conn.Write([]byte("@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE #test :Your message was not sent because you are sending messages too quickly.\r\n"))
+
+	select {
+	case err := <-reported:
+		var notice *birc.NoticeError
+		if !errors.Is(err, birc.ErrRateLimited) || !errors.As(err, &notice) || notice.ID != "msg_ratelimit" {
+			t.Errorf("expected a rate limit notice, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the rate limit to be reported")
+	}
+}
@@ -0,0 +1,156 @@
+package birc
+
+import (
+	"strconv"
+	"time"
+
+	sirc "github.com/sorcix/irc"
+)
+
+// Event is a typed notification emitted by a Channel.
+type Event interface {
+	event()
+}
+
+// StateChange is emitted whenever a Channel moves to another State.
+type StateChange struct {
+	From State
+	To   State
+	// Err is the error that stopped the listener when To is StateClosed.
+	Err error
+}
+
+// ChatEvent is a chat message.
+type ChatEvent struct {
+	Message
+	// Bits is the number of bits cheered with the message.
+	Bits int
+}
+
+// SubEvent is a new subscription or a resubscription.
+type SubEvent struct {
+	Message
+	User string
+	// Plan is the subscription plan: Prime, 1000, 2000 or 3000.
+	Plan   string
+	Months int
+	Resub  bool
+}
+
+// SubGiftEvent is a subscription gifted to another user.
+type SubGiftEvent struct {
+	Message
+	User      string
+	Plan      string
+	Recipient string
+	Anonymous bool
+}
+
+// RaidEvent is another channel raiding this one.
+type RaidEvent struct {
+	Message
+	Raider  string
+	Viewers int
+}
+
+// ClearChatEvent is a purge of the whole chat when User is empty, or of a single
+// user's messages because they were timed out or banned.
+type ClearChatEvent struct {
+	Message
+	User string
+	// Duration is the length of a timeout. It is zero for bans.
+	Duration time.Duration
+}
+
+// ClearMsgEvent is a single message being deleted.
+type ClearMsgEvent struct {
+	Message
+	User      string
+	MessageID string
+}
+
+// NoticeEvent is a notice from the server, such as a rate limit warning.
+type NoticeEvent struct {
+	Message
+	// ID is the msg-id tag identifying the notice.
+	ID string
+}
+
+// RoomStateEvent is a change to the channel's chat settings, such as slow mode.
+type RoomStateEvent struct {
+	Message
+}
+
+func (StateChange) event()    {}
+func (ChatEvent) event()      {}
+func (SubEvent) event()       {}
+func (SubGiftEvent) event()   {}
+func (RaidEvent) event()      {}
+func (ClearChatEvent) event() {}
+func (ClearMsgEvent) event()  {}
+func (NoticeEvent) event()    {}
+func (RoomStateEvent) event() {}
+
+// ParseEvent converts a message into its typed Event, or returns nil if it
+// doesn't have one.
+func ParseEvent(m Message) Event {
+	switch m.Command {
+	case sirc.PRIVMSG:
+		return ChatEvent{Message: m, Bits: atoi(m.Tags["bits"])}
+	case "USERNOTICE":
+		switch id := m.Tags["msg-id"]; id {
+		case "sub", "resub":
+			return SubEvent{
+				Message: m,
+				User:    m.Tags["login"],
+				Plan:    m.Tags["msg-param-sub-plan"],
+				Months:  atoi(m.Tags["msg-param-cumulative-months"]),
+				Resub:   id == "resub",
+			}
+		case "subgift", "anonsubgift":
+			return SubGiftEvent{
+				Message:   m,
+				User:      m.Tags["login"],
+				Plan:      m.Tags["msg-param-sub-plan"],
+				Recipient: m.Tags["msg-param-recipient-user-name"],
+				Anonymous: id == "anonsubgift",
+			}
+		case "raid":
+			return RaidEvent{
+				Message: m,
+				Raider:  m.Tags["msg-param-login"],
+				Viewers: atoi(m.Tags["msg-param-viewerCount"]),
+			}
+		}
+	case "CLEARCHAT":
+		return ClearChatEvent{
+			Message:  m,
+			User:     m.Content,
+			Duration: time.Duration(atoi(m.Tags["ban-duration"])) * time.Second,
+		}
+	case "CLEARMSG":
+		return ClearMsgEvent{Message: m, User: m.Tags["login"], MessageID: m.Tags["target-msg-id"]}
+	case sirc.NOTICE:
+		return NoticeEvent{Message: m, ID: m.Tags["msg-id"]}
+	case "ROOMSTATE":
+		return RoomStateEvent{Message: m}
+	}
+	return nil
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+// On registers handler for events of type T, such as On[RaidEvent]. It goes
+// through the same dispatch as digesters, so handler must be thread safe. The
+// returned Handle removes it with Unregister. Lifecycle events such as
+// StateChange aren't produced by messages and are only available from Events.
+func On[T Event](c *Channel, handler func(e T, w ChannelWriter)) Handle {
+	return c.Register(func(m Message, w ChannelWriter) {
+		if e, ok := ParseEvent(m).(T); ok {
+			handler(e, w)
+		}
+	})
+}
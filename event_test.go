@@ -0,0 +1,60 @@
+package birc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func parse(t *testing.T, line string) birc.Message {
+	m := birc.ParseMessage(line)
+	if m == nil {
+		t.Fatalf("failed to parse %s", line)
+	}
+	return *m
+}
+
+func TestParseEvent(t *testing.T) {
+	e := birc.ParseEvent(parse(t, `@login=bob;msg-id=resub;msg-param-cumulative-months=6;msg-param-sub-plan=1000 :tmi.twitch.tv USERNOTICE #test :Great stream`))
+	if sub, ok := e.(birc.SubEvent); !ok || !sub.Resub || sub.User != "bob" || sub.Months != 6 || sub.Plan != "1000" || sub.Content != "Great stream" {
+		t.Errorf("unexpected sub event %+v", e)
+	}
+
+	e = birc.ParseEvent(parse(t, `@msg-id=raid;msg-param-login=bob;msg-param-viewerCount=42 :tmi.twitch.tv USERNOTICE #test`))
+	if raid, ok := e.(birc.RaidEvent); !ok || raid.Raider != "bob" || raid.Viewers != 42 {
+		t.Errorf("unexpected raid event %+v", e)
+	}
+
+	e = birc.ParseEvent(parse(t, `@ban-duration=600 :tmi.twitch.tv CLEARCHAT #test :spammer`))
+	if clear, ok := e.(birc.ClearChatEvent); !ok || clear.User != "spammer" || clear.Duration != 10*time.Minute {
+		t.Errorf("unexpected clearchat event %+v", e)
+	}
+
+	if e := birc.ParseEvent(parse(t, ":tmi.twitch.tv 001 foobar :Welcome, GLHF!")); e != nil {
+		t.Errorf("expected no event, got %+v", e)
+	}
+}
+
+func TestOn(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	raids := make(chan birc.RaidEvent, 1)
+	birc.On(c, func(e birc.RaidEvent, w birc.ChannelWriter) {
+		raids <- e
+	})
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+	conn.Write([]byte("@msg-id=raid;msg-param-login=alice;msg-param-viewerCount=7 :tmi.twitch.tv USERNOTICE #test\r\n"))
+
+	select {
+	case e := <-raids:
+		if e.Raider != "alice" || e.Viewers != 7 {
+			t.Errorf("unexpected raid %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a raid event")
+	}
+}
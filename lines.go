@@ -0,0 +1,118 @@
+package birc
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	sirc "github.com/sorcix/irc"
+)
+
+// LineHook observes a raw IRC line, without its line ending. Credentials are
+// redacted before the hook sees them.
+type LineHook func(line string)
+
+// LineRewriter can replace a raw IRC line or drop it by returning false. It
+// sees the line unredacted, before any LineHook.
+type LineRewriter func(line string) (string, bool)
+
+type lineHooks struct {
+	sync.RWMutex
+	inbound           []LineHook
+	outbound          []LineHook
+	inboundRewriters  []LineRewriter
+	outboundRewriters []LineRewriter
+}
+
+// OnInbound registers a hook that sees every line received, before it is parsed.
+func (c *Channel) OnInbound(h LineHook) {
+	c.lines.Lock()
+	c.lines.inbound = append(c.lines.inbound, h)
+	c.lines.Unlock()
+}
+
+// OnOutbound registers a hook that sees every line sent, after it is encoded.
+func (c *Channel) OnOutbound(h LineHook) {
+	c.lines.Lock()
+	c.lines.outbound = append(c.lines.outbound, h)
+	c.lines.Unlock()
+}
+
+// RewriteInbound registers a rewriter for received lines. It is meant for
+// debugging and injecting faults in tests.
+func (c *Channel) RewriteInbound(r LineRewriter) {
+	c.lines.Lock()
+	c.lines.inboundRewriters = append(c.lines.inboundRewriters, r)
+	c.lines.Unlock()
+}
+
+// RewriteOutbound registers a rewriter for sent lines. It is meant for
+// debugging and injecting faults in tests.
+func (c *Channel) RewriteOutbound(r LineRewriter) {
+	c.lines.Lock()
+	c.lines.outboundRewriters = append(c.lines.outboundRewriters, r)
+	c.lines.Unlock()
+}
+
+// filter runs line through the rewriters and then the hooks. It reports false
+// if a rewriter dropped the line.
+func filter(line string, rewriters []LineRewriter, hooks []LineHook) (string, bool) {
+	for _, r := range rewriters {
+		var keep bool
+		if line, keep = r(line); !keep {
+			return "", false
+		}
+	}
+	if len(hooks) > 0 {
+		redacted := redact(line)
+		for _, h := range hooks {
+			h(redacted)
+		}
+	}
+	return line, true
+}
+
+// inbound filters a received line.
+func (c *Channel) inbound(line string) (string, bool) {
+	c.lines.RLock()
+	rewriters, hooks := c.lines.inboundRewriters, c.lines.inbound
+	c.lines.RUnlock()
+
+	if len(rewriters) == 0 && len(hooks) == 0 {
+		return line, true
+	}
+	return filter(strings.TrimRight(line, "\r\n"), rewriters, hooks)
+}
+
+// encode filters m's line and writes it with w.
+func (c *Channel) encode(w Encoder, m *sirc.Message) error {
+	c.lines.RLock()
+	rewriters, hooks := c.lines.outboundRewriters, c.lines.outbound
+	c.lines.RUnlock()
+
+	if len(rewriters) == 0 && len(hooks) == 0 {
+		return w.Encode(m)
+	}
+
+	original := m.String()
+	line, keep := filter(original, rewriters, hooks)
+	if !keep {
+		return nil
+	}
+	if line != original {
+		if m = sirc.ParseMessage(line); m == nil {
+			return nil
+		}
+	}
+	return w.Encode(m)
+}
+
+var oauthToken = regexp.MustCompile(`oauth:\S+`)
+
+// redact hides credentials in a raw line.
+func redact(line string) string {
+	if len(line) > 5 && strings.EqualFold(line[:5], "PASS ") {
+		return line[:5] + "[redacted]"
+	}
+	return oauthToken.ReplaceAllString(line, "oauth:[redacted]")
+}
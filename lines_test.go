@@ -0,0 +1,77 @@
+package birc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	sirc "github.com/sorcix/irc"
+)
+
+func TestOutboundHooksRedactCredentials(t *testing.T) {
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+
+	var encoded []string
+	c.SetWriter(&Writer{func(m *sirc.Message) {
+		encoded = append(encoded, m.String())
+	}})
+
+	var seen []string
+	c.OnOutbound(func(line string) {
+		seen = append(seen, line)
+	})
+	c.RewriteOutbound(func(line string) (string, bool) {
+		if strings.HasPrefix(line, "NICK") {
+			return "NICK rewritten", true
+		}
+		return line, !strings.HasPrefix(line, "CAP")
+	})
+
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"PASS [redacted]", "NICK rewritten", "JOIN #test"}
+	if len(seen) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], seen[i])
+		}
+	}
+	if encoded[0] != "PASS oauth:abc123" || encoded[1] != "NICK rewritten" {
+		t.Errorf("expected the real lines to be written, got %v", encoded)
+	}
+}
+
+func TestInboundHooks(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	seen := make(chan string, 2)
+	c.OnInbound(func(line string) {
+		seen <- line
+	})
+	c.RewriteInbound(func(line string) (string, bool) {
+		return strings.Replace(line, "hi", "hello", 1), !strings.Contains(line, "drop")
+	})
+	messages := c.Messages(2, birc.OverflowBlock)
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :drop me\r\n"))
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi\r\n"))
+
+	select {
+	case m := <-messages:
+		if m.Content != "hello" {
+			t.Errorf("expected the rewritten message, got %q", m.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	if line := <-seen; line != ":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hello" {
+		t.Errorf("expected the hook to see the rewritten line, got %q", line)
+	}
+}
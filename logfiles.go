@@ -0,0 +1,267 @@
+package birc
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dayFormat = "2006-01-02"
+
+// LogFiles archives chat to one file per channel and day, one line per message
+// in the format of CustomLogger, each ending in a newline. Files are named
+// <Dir>/<channel>/<day>.log, where the day is the local date the message was
+// sent. A file is rotated when a message from a later day arrives or, with
+// MaxSize set, when the next line would make it larger than MaxSize bytes.
+// Files rotated for their size are moved aside with the next sequence number,
+// so 2017-10-05.1.log holds the earliest lines of the day, 2017-10-05.2.log the
+// ones after them and the bare 2017-10-05.log the latest. With Compress set,
+// rotated files are gzipped in the background, e.g. to 2017-10-05.1.log.gz and
+// 2017-10-05.log.gz, and so are the files of earlier days a previous run left
+// behind once a channel is first written to. Messages that arrive late for a
+// day that was already rotated go to the current file, and a day that is
+// written to again after it was compressed, e.g. by a replay, continues under
+// the next sequence number rather than replacing the archive.
+//
+// Lines are formatted like CustomLogger's but written by LogFiles itself rather
+// than through a CustomLogger per file, since it needs to know each line's size
+// before picking the file, ends every line with a newline and reports write
+// errors. Every line is written with a single append, and a line that could
+// only be written in part, e.g. because the disk is full, is truncated away
+// again. Compressed files are only renamed into place once complete. A
+// LogFiles is safe for concurrent use but must not be copied.
+type LogFiles struct {
+	Dir      string
+	MaxSize  int64
+	Compress bool
+
+	mu          sync.Mutex
+	files       map[string]*logFile
+	swept       map[string]bool
+	compressing sync.WaitGroup
+	err         error
+}
+
+type logFile struct {
+	*os.File
+	day  string
+	size int64
+}
+
+// Digest is the LogFiles' Digester. Write errors are reported to the
+// ChannelWriter if it is an ErrorReporter.
+func (l *LogFiles) Digest(m Message, c ChannelWriter) {
+	line, ok := logLine(m)
+	if !ok {
+		return
+	}
+	channel := strings.TrimPrefix(ByChannel(m), "#")
+	if channel == "" && c != nil {
+		channel = c.GetConfig().ChannelName
+	}
+	day := m.Timestamp().Local().Format(dayFormat)
+
+	err := l.write(channel, day, []byte(line+"\n"))
+	if r, ok := c.(ErrorReporter); ok && err != nil {
+		r.ReportError(err, m)
+	}
+}
+
+// Close closes every open file and waits for rotated files to be compressed.
+// It returns the first error that happened while compressing or closing.
+func (l *LogFiles) Close() error {
+	l.mu.Lock()
+	for channel, f := range l.files {
+		if err := f.Close(); err != nil && l.err == nil {
+			l.err = err
+		}
+		delete(l.files, channel)
+	}
+	l.mu.Unlock()
+
+	l.compressing.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *LogFiles) write(channel, day string, p []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := logName(channel)
+	dir := filepath.Join(l.Dir, name)
+	f := l.files[name]
+	if f != nil {
+		if day < f.day {
+			day = f.day
+		}
+		full := l.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > l.MaxSize
+		if day > f.day || full {
+			delete(l.files, name)
+			if err := l.rotate(dir, f, full); err != nil {
+				return err
+			}
+			f = nil
+		}
+	}
+
+	if f == nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if l.Compress && !l.swept[name] {
+			if err := l.sweep(dir, day); err != nil {
+				return err
+			}
+			if l.swept == nil {
+				l.swept = map[string]bool{}
+			}
+			l.swept[name] = true
+		}
+		file, err := os.OpenFile(filepath.Join(dir, day+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		f = &logFile{File: file, day: day, size: info.Size()}
+		if l.files == nil {
+			l.files = map[string]*logFile{}
+		}
+		l.files[name] = f
+	}
+
+	n, err := f.Write(p)
+	if err != nil && n > 0 && f.Truncate(f.size) == nil {
+		// The partial line was taken back.
+		n = 0
+	}
+	f.size += int64(n)
+	return err
+}
+
+// rotate closes f and, if it is full, moves it aside under the next free
+// sequence number so the day can continue in a new file. It is also moved
+// aside if the day was already archived, so the archive isn't replaced.
+func (l *LogFiles) rotate(dir string, f *logFile, full bool) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	rotated := f.Name()
+	if full || exists(rotated+".gz") {
+		for i := 1; ; i++ {
+			rotated = filepath.Join(dir, fmt.Sprintf("%s.%d.log", f.day, i))
+			if !exists(rotated) && !exists(rotated+".gz") {
+				break
+			}
+		}
+		if err := os.Rename(f.Name(), rotated); err != nil {
+			return err
+		}
+	}
+
+	if l.Compress {
+		l.compressLater(rotated)
+	}
+	return nil
+}
+
+// sweep compresses the files a previous run left behind in dir: those of days
+// before day and those of day that were rotated for their size.
+func (l *LogFiles) sweep(dir, day string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".log")
+		fileDay, _, _ := strings.Cut(name, ".")
+		if _, err := time.Parse(dayFormat, fileDay); err != nil || fileDay > day || name == day {
+			continue
+		}
+		l.compressLater(path)
+	}
+	return nil
+}
+
+// compressLater compresses path in the background. Errors are returned by
+// Close.
+func (l *LogFiles) compressLater(path string) {
+	l.compressing.Add(1)
+	go func() {
+		defer l.compressing.Done()
+		if err := compress(path); err != nil {
+			l.mu.Lock()
+			if l.err == nil {
+				l.err = err
+			}
+			l.mu.Unlock()
+		}
+	}()
+}
+
+// compress gzips path into path.gz and removes path. The archive is written to
+// a temporary file first so a partial archive never appears under its name.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".gz.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// logName makes a channel name safe to use as a directory name.
+func logName(channel string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return -1
+	}, channel)
+	if name == "" {
+		return "_"
+	}
+	return strings.ToLower(name)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
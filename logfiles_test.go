@@ -0,0 +1,121 @@
+package birc_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	logs := &birc.LogFiles{Dir: dir, MaxSize: 100, Compress: true}
+
+	day := time.Date(2017, 10, 5, 12, 0, 0, 0, time.Local)
+	say := func(channel, content string, at time.Time) {
+		logs.Digest(birc.Message{Username: "bob", Content: content, Params: []string{channel}, ServerTime: at}, nil)
+	}
+	say("#Dallas", "first message of the day", day)
+	say("#dallas", "second message, which is too big", day.Add(time.Minute))
+	say("#ronni", "somewhere else", day)
+	say("#dallas", "the next day", day.Add(24*time.Hour))
+	say("#dallas", "a late message", day.Add(2*time.Minute))
+	if err := logs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"dallas/2017-10-05.1.log.gz": {"first message of the day"},
+		"dallas/2017-10-05.log.gz":   {"second message, which is too big"},
+		"dallas/2017-10-06.log":      {"the next day", "a late message"},
+		"ronni/2017-10-05.log":       {"somewhere else"},
+	}
+
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	sort.Strings(files)
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, got %q", len(expected), files)
+	}
+
+	for name, contents := range expected {
+		lines := readLog(t, filepath.Join(dir, name))
+		if len(lines) != len(contents) {
+			t.Errorf("expected %d lines in %s, got %q", len(contents), name, lines)
+			continue
+		}
+		for i, content := range contents {
+			if !strings.HasSuffix(lines[i], "bob: "+content) {
+				t.Errorf("expected %q in %s, got %q", content, name, lines[i])
+			}
+		}
+	}
+}
+
+func TestLogFilesCompressesLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	channel := filepath.Join(dir, "dallas")
+	os.MkdirAll(channel, 0755)
+	for _, name := range []string{"2017-10-04.log", "2017-10-05.1.log", "2017-10-05.log", "2017-10-06.log", "notes.log"} {
+		os.WriteFile(filepath.Join(channel, name), []byte("2017-10-04 12:00:00 bob: "+name+"\n"), 0644)
+	}
+
+	// The previous run stopped before compressing the 4th and the first part of
+	// the 5th, and a late message for the 5th arrives after the restart.
+	logs := &birc.LogFiles{Dir: dir, Compress: true}
+	day := time.Date(2017, 10, 5, 12, 0, 0, 0, time.Local)
+	logs.Digest(birc.Message{Username: "bob", Content: "late", Params: []string{"#dallas"}, ServerTime: day}, nil)
+	if err := logs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	entries, _ := os.ReadDir(channel)
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	expected := []string{"2017-10-04.log.gz", "2017-10-05.1.log.gz", "2017-10-05.log", "2017-10-06.log", "notes.log"}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %q, got %q", expected, files)
+	}
+	if lines := readLog(t, filepath.Join(channel, "2017-10-05.log")); len(lines) != 2 || !strings.HasSuffix(lines[1], "bob: late") {
+		t.Errorf("expected the late message to be appended, got %q", lines)
+	}
+}
+
+func readLog(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\n") || strings.HasPrefix(string(b), "\n") {
+		t.Errorf("expected newline terminated lines in %s, got %q", path, b)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
@@ -1,7 +1,11 @@
 package birc
 
 import (
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	sirc "github.com/sorcix/irc"
 )
@@ -14,31 +18,261 @@ type Message struct {
 	Command  string
 	Host     string
 	Params   []string
-	Time     time.Time
+	// Time is when the message was received locally.
+	Time time.Time
+	// ServerTime is when Twitch sent the message, taken from the tmi-sent-ts
+	// tag. It is zero if the tag is missing.
+	ServerTime time.Time
+	// Tags contains the IRCv3 tags Twitch attaches to the message, such as
+	// badges, color and display-name.
+	Tags map[string]string
+	// Trusted allows the content of an outgoing chat message to start with a
+	// Twitch chat command such as /ban. Leave it unset for anything that may
+	// contain user input.
+	Trusted bool
 }
 
-// prepare converts a Message struct into an IRC messsage
+// ParseMessage parses a raw IRC line, including any IRCv3 tags. It returns nil
+// if the line isn't a valid message.
+func ParseMessage(raw string) *Message {
+	raw = strings.TrimRight(raw, "\r\n")
+
+	var tags map[string]string
+	if strings.HasPrefix(raw, "@") {
+		i := strings.IndexByte(raw, ' ')
+		if i < 0 {
+			return nil
+		}
+		tags = parseTags(raw[1:i])
+		raw = raw[i+1:]
+	}
+
+	message := &Message{Tags: tags, ServerTime: sentAt(tags)}
+	if strings.HasPrefix(raw, ":") {
+		i := strings.IndexByte(raw, ' ')
+		if i < 2 {
+			return nil
+		}
+		prefix := sirc.ParsePrefix(raw[1:i])
+		message.Name, message.Username, message.Host = prefix.Name, prefix.User, prefix.Host
+		raw = raw[i+1:]
+	}
+
+	// The trailing parameter starts at the first " :", and may contain
+	// anything. The parameters before it are separated by spaces.
+	if strings.HasPrefix(raw, ":") {
+		raw, message.Content = "", raw[1:]
+	} else if i := strings.Index(raw, " :"); i >= 0 {
+		raw, message.Content = raw[:i], raw[i+2:]
+	}
+	var words []string
+	for _, word := range strings.Split(raw, " ") {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 || strings.IndexFunc(words[0], func(r rune) bool { return !isCommandRune(r) }) >= 0 {
+		return nil
+	}
+	message.Command = strings.ToUpper(words[0])
+	if len(words) > 1 {
+		message.Params = words[1:]
+	}
+	return message
+}
+
+// parseTags parses the tags section of a message, without the leading @.
+func parseTags(raw string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+			continue
+		}
+		tags[kv[0]] = unescapeTag(kv[1])
+	}
+	return tags
+}
+
+// unescapeTag reverses the IRCv3 escaping of a tag value. Unknown escapes
+// drop the backslash and a trailing lone backslash is removed.
+func unescapeTag(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i++; i == len(v) {
+			break
+		}
+		switch v[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
+// sentAt converts the tmi-sent-ts tag, in milliseconds since the epoch, to a time.
+func sentAt(tags map[string]string) time.Time {
+	ms, err := strconv.ParseInt(tags["tmi-sent-ts"], 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Timestamp returns when the message was sent by Twitch if known, otherwise
+// when it was received.
+func (m Message) Timestamp() time.Time {
+	if !m.ServerTime.IsZero() {
+		return m.ServerTime
+	}
+	return m.Time
+}
+
+// Badges returns the user's badges from the badges tag, mapping each badge to
+// its version, e.g. "subscriber" to "12".
+func (m Message) Badges() map[string]string {
+	badges := map[string]string{}
+	for _, badge := range strings.Split(m.Tags["badges"], ",") {
+		if badge == "" {
+			continue
+		}
+		kv := strings.SplitN(badge, "/", 2)
+		if len(kv) == 2 {
+			badges[kv[0]] = kv[1]
+		} else {
+			badges[kv[0]] = ""
+		}
+	}
+	return badges
+}
+
+// Sanitize returns a copy of m the way SendMessage sends it. Every field is
+// sanitized so it can't break out into another line or parameter, and chat
+// commands are stripped from untrusted chat messages. Like SendMessage it
+// returns ErrMessageTooLong for chat messages longer than MaxMessageLength
+// characters and for messages that don't fit in a line of MaxLineLength bytes.
+func Sanitize(m *Message) (*Message, error) {
+	if m.Command == sirc.PRIVMSG && utf8.RuneCountInString(m.Content) > MaxMessageLength {
+		return nil, ErrMessageTooLong
+	}
+	sanitized := m.sanitized()
+	if sanitized.prepare().Len() > MaxLineLength {
+		return nil, ErrMessageTooLong
+	}
+	return sanitized, nil
+}
+
+// sanitized returns a copy of m with every field sanitized, see Sanitize.
+func (m *Message) sanitized() *Message {
+	message := *m
+	message.Command = sanitizeCommand(m.Command)
+	message.Content = sanitizeLine(m.Content)
+	message.Params = nil
+	for _, p := range m.Params {
+		if p = sanitizeWord(p); p != "" {
+			message.Params = append(message.Params, p)
+		}
+	}
+	if message.Command == sirc.PRIVMSG && !m.Trusted {
+		message.Content = stripChatCommand(message.Content)
+	}
+	message.Name, message.Username, message.Host = sanitizeWord(m.Name), sanitizeWord(m.Username), sanitizeWord(m.Host)
+	return &message
+}
+
+// prepare converts a sanitized Message struct into an IRC messsage.
 func (m *Message) prepare() *sirc.Message {
 	message := &sirc.Message{
 		Command:  m.Command,
 		Params:   m.Params,
 		Trailing: m.Content,
 	}
-
 	if m.Name != "" || m.Username != "" || m.Host != "" {
-		message.Prefix = &sirc.Prefix{}
+		message.Prefix = &sirc.Prefix{Name: m.Name, User: m.Username, Host: m.Host}
 	}
-	if m.Name != "" {
-		message.Name = m.Name
-	}
-	if m.Username != "" {
-		message.User = m.Username
-	}
-	if m.Host != "" {
-		message.Host = m.Host
+	return message
+}
+
+// sanitizeLine replaces line breaks and removes NUL characters.
+func sanitizeLine(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\r', '\n':
+			return ' '
+		case 0:
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeCommand keeps only the letters and digits IRC commands are made of.
+// Commands are case insensitive, so it also uppercases them.
+func sanitizeCommand(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isCommandRune(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+func isCommandRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// sanitizeWord removes everything that would end a parameter or the line, and
+// leading colons that would turn it into the trailing parameter.
+func sanitizeWord(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\r', '\n', 0:
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimLeft(s, ":")
+}
+
+// stripChatCommand removes the slash or dot Twitch uses to recognise a chat
+// command such as /ban or .timeout at the start of s, along with any
+// whitespace before it. Content that merely starts with slashes or dots, such
+// as "...really?", is left alone.
+func stripChatCommand(s string) string {
+	if trimmed := strings.TrimLeftFunc(s, unicode.IsSpace); isChatCommand(trimmed) {
+		return trimmed[1:]
 	}
+	return s
+}
 
-	return message
+// isChatCommand reports whether s starts with a slash or dot followed by a
+// command name.
+func isChatCommand(s string) bool {
+	if len(s) < 2 || s[0] != '/' && s[0] != '.' {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[1:])
+	return unicode.IsLetter(r)
 }
 
 // PongMessage returns a Message struct containing a PONG message,
@@ -0,0 +1,372 @@
+package birc_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+	sirc "github.com/sorcix/irc"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestParseMessageTags(t *testing.T) {
+	m := birc.ParseMessage(`@badges=moderator/1,subscriber/12;display-name=Bob;system-msg=hello\sthere\:\\;flag :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi there` + "\r\n")
+	if m == nil {
+		t.Fatal("expected a message")
+	}
+
+	if m.Command != "PRIVMSG" || m.Username != "bob" || m.Content != "hi there" || m.Params[0] != "#test" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m.Tags["display-name"] != "Bob" {
+		t.Errorf("expected display-name Bob, got %q", m.Tags["display-name"])
+	}
+	if m.Tags["system-msg"] != `hello there;\` {
+		t.Errorf("expected tag value to be unescaped, got %q", m.Tags["system-msg"])
+	}
+	if v, ok := m.Tags["flag"]; !ok || v != "" {
+		t.Errorf("expected empty flag tag, got %q", v)
+	}
+	if m.Badges()["subscriber"] != "12" {
+		t.Errorf("expected subscriber badge 12, got %v", m.Badges())
+	}
+}
+
+func TestParseMessageServerTime(t *testing.T) {
+	m := birc.ParseMessage("@tmi-sent-ts=1507246572675 :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi")
+	if !m.ServerTime.Equal(time.Unix(1507246572, 675000000)) {
+		t.Errorf("unexpected server time %v", m.ServerTime)
+	}
+
+	m.Time = time.Now()
+	if !m.Timestamp().Equal(m.ServerTime) {
+		t.Errorf("expected timestamp to prefer the server time, got %v", m.Timestamp())
+	}
+
+	m = birc.ParseMessage("@tmi-sent-ts=bogus :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi")
+	if !m.ServerTime.IsZero() {
+		t.Errorf("expected no server time, got %v", m.ServerTime)
+	}
+	m.Time = time.Now()
+	if !m.Timestamp().Equal(m.Time) {
+		t.Errorf("expected timestamp to fall back to the receive time, got %v", m.Timestamp())
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	for _, line := range []string{"", "\r\n", "@badges=", ":prefix-only"} {
+		if m := birc.ParseMessage(line); m != nil {
+			t.Errorf("expected %q to be invalid, got %+v", line, m)
+		}
+	}
+}
+
+func TestParseMessageTrailing(t *testing.T) {
+	m := birc.ParseMessage("PRIVMSG #a:b  bob :hi :there")
+	if m == nil || len(m.Params) != 2 || m.Params[0] != "#a:b" || m.Params[1] != "bob" || m.Content != "hi :there" {
+		t.Errorf("expected the trailing parameter to start at the first space and colon, got %+v", m)
+	}
+}
+
+// parsed is the golden form of a parsed message.
+type parsed struct {
+	Line       string
+	Name       string            `json:",omitempty"`
+	Username   string            `json:",omitempty"`
+	Host       string            `json:",omitempty"`
+	Command    string            `json:",omitempty"`
+	Params     []string          `json:",omitempty"`
+	Content    string            `json:",omitempty"`
+	Tags       map[string]string `json:",omitempty"`
+	ServerTime string            `json:",omitempty"`
+	Event      string            `json:",omitempty"`
+}
+
+func TestParseMessageGolden(t *testing.T) {
+	f, err := os.Open("testdata/twitch.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []parsed
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := parsed{Line: line}
+		if m := birc.ParseMessage(line); m != nil {
+			p.Name, p.Username, p.Host = m.Name, m.Username, m.Host
+			p.Command, p.Params, p.Content, p.Tags = m.Command, m.Params, m.Content, m.Tags
+			if !m.ServerTime.IsZero() {
+				p.ServerTime = m.ServerTime.UTC().Format(time.RFC3339Nano)
+			}
+			if e := birc.ParseEvent(*m); e != nil {
+				p.Event = fmt.Sprintf("%T", e)
+			}
+		}
+		got = append(got, p)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		b, err := json.MarshalIndent(got, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("testdata/twitch.golden.json", append(b, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile("testdata/twitch.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []parsed
+	if err := json.Unmarshal(b, &expected); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d golden messages, got %d, run with -update", len(expected), len(got))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("expected %+v, got %+v", expected[i], got[i])
+		}
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	f.Add("@badges=subscriber/12;display-name=Bob :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi there")
+	f.Add(":tmi.twitch.tv 001 foobar :Welcome, GLHF!")
+	f.Add("PING :tmi.twitch.tv")
+	f.Add("@msg-id=slow_on :tmi.twitch.tv NOTICE #test :slow\r\n")
+	f.Add("PRIVMSG #a:b  bob ::hi")
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		m := birc.ParseMessage(raw)
+		if m == nil {
+			return
+		}
+		if m.Command == "" || strings.Contains(m.Command, " ") {
+			t.Fatalf("invalid command %q", m.Command)
+		}
+		for _, p := range m.Params {
+			if p == "" || strings.Contains(p, " ") {
+				t.Fatalf("invalid parameter %q", p)
+			}
+		}
+
+		// Whatever was parsed must survive being sent and parsed again.
+		m.Trusted = true
+		line := send(t, m)
+		if line == "" {
+			return
+		}
+		again := birc.ParseMessage(line)
+		if again == nil {
+			t.Fatalf("failed to parse sent line %q", line)
+		}
+		again.Trusted = true
+		if resent := send(t, again); resent != line {
+			t.Fatalf("expected %q to round trip, got %q", line, resent)
+		}
+	})
+}
+
+func FuzzPrepareRoundTrip(f *testing.F) {
+	f.Add("PRIVMSG", "#test", "hi\r\nthere")
+	f.Add("NOTICE", ":#test", ":hi")
+	f.Add("privmsg", "#test", "/ban bob")
+	f.Add("PRIVMSG", "#a b", "/ban bob")
+
+	f.Fuzz(func(t *testing.T, command, param, content string) {
+		if strings.Trim(command, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") == command {
+			// Nothing is left of the command once it's sanitized.
+			return
+		}
+		m := &birc.Message{Command: command, Params: []string{param}, Content: content}
+		line := send(t, m)
+		if line == "" {
+			return
+		}
+
+		got := birc.ParseMessage(line)
+		if got == nil {
+			t.Fatalf("failed to parse sent line %q", line)
+		}
+		if resent := send(t, got); resent != line {
+			t.Fatalf("expected %q to round trip, got %q", line, resent)
+		}
+	})
+}
+
+func FuzzParseTags(f *testing.F) {
+	f.Add(`badges=moderator/1,subscriber/12;display-name=Bob`)
+	f.Add(`system-msg=hello\sthere\:\\;flag;empty=`)
+	f.Add(`a=\;b=\x;=c;;d=e=f`)
+
+	f.Fuzz(func(t *testing.T, tags string) {
+		if strings.ContainsAny(tags, " \r\n") {
+			return
+		}
+		m := birc.ParseMessage("@" + tags + " :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi")
+		if m == nil {
+			t.Fatalf("failed to parse tags %q", tags)
+		}
+
+		formatted := birctest.FormatTags(m.Tags)
+		again := birc.ParseMessage(formatted + " :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi")
+		if again == nil || !reflect.DeepEqual(m.Tags, again.Tags) {
+			t.Fatalf("expected %q to round trip through %q, got %+v", tags, formatted, again)
+		}
+	})
+}
+
+// send returns the line a Channel writes for m, or an empty string if it
+// wasn't sent or would be truncated.
+func send(t *testing.T, m *birc.Message) string {
+	c, lines := sent()
+	if err := c.SendMessage(m); err != nil || len(*lines) == 0 {
+		return ""
+	}
+	if line := (*lines)[0]; len(line) < 510 {
+		return line
+	}
+	return ""
+}
+
+// sent returns a Channel that records the lines it writes.
+func sent() (*birc.Channel, *[]string) {
+	var lines []string
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.SetWriter(&Writer{func(m *sirc.Message) {
+		lines = append(lines, m.String())
+	}})
+	return c, &lines
+}
+
+func TestSendSanitizesContent(t *testing.T) {
+	c, lines := sent()
+	c.Send("hi\r\nPRIVMSG #test :/ban bob")
+	c.Send(" /ban bob")
+	c.Send(".timeout bob")
+	c.SendTrusted("/slow 10\r\nPRIVMSG #other :hi")
+	c.SendMessage(&birc.Message{Command: "PRIVMSG", Params: []string{"#test :/ban", "bob"}, Content: "hi"})
+	c.SendMessage(&birc.Message{Command: "privmsg", Params: []string{"#test"}, Content: "/ban bob"})
+	c.SendMessage(&birc.Message{Command: "@ban=1 PRIVMSG", Params: []string{"#test"}, Content: "hi"})
+	c.Send("...really?")
+	c.Send("/\\ roof")
+	c.Send("./ban bob")
+	c.Send("\t/ban x")
+	c.Send("\u3000/ban x")
+
+	expected := []string{
+		":foobar!foobar PRIVMSG #test :hi  PRIVMSG #test :/ban bob",
+		":foobar!foobar PRIVMSG #test :ban bob",
+		":foobar!foobar PRIVMSG #test :timeout bob",
+		":foobar!foobar PRIVMSG #test :/slow 10  PRIVMSG #other :hi",
+		"PRIVMSG #test:/ban bob :hi",
+		"PRIVMSG #test :ban bob",
+		"BAN1PRIVMSG #test :hi",
+		":foobar!foobar PRIVMSG #test :...really?",
+		":foobar!foobar PRIVMSG #test :/\\ roof",
+		":foobar!foobar PRIVMSG #test :./ban bob",
+		":foobar!foobar PRIVMSG #test :ban x",
+		":foobar!foobar PRIVMSG #test :ban x",
+	}
+	if len(*lines) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, *lines)
+	}
+	for i := range expected {
+		if (*lines)[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], (*lines)[i])
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	m := &birc.Message{Command: "PRIVMSG", Params: []string{"#test"}, Content: "/ban bob\nhi"}
+	sanitized, err := birc.Sanitize(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sanitized.Content != "ban bob hi" || m.Content != "/ban bob\nhi" {
+		t.Errorf("expected a sanitized copy, got %q from %q", sanitized.Content, m.Content)
+	}
+
+	m.Content = strings.Repeat("a", birc.MaxMessageLength)
+	m.Params = []string{"#" + strings.Repeat("a", birc.MaxLineLength)}
+	if _, err := birc.Sanitize(m); err != birc.ErrMessageTooLong {
+		t.Errorf("expected ErrMessageTooLong, got %v", err)
+	}
+}
+
+func FuzzSendCannotInject(f *testing.F) {
+	f.Add("hi\r\nPRIVMSG #test :/ban bob", "#test")
+	f.Add("/ban bob", "#test :/ban")
+	f.Add(" . /timeout bob", "\n#test")
+	f.Add("\x00/me waves", ":#test")
+
+	f.Fuzz(func(t *testing.T, content, channel string) {
+		c, lines := sent()
+		if err := c.SendMessage(&birc.Message{Command: "PRIVMSG", Params: []string{channel}, Content: content}); err != nil {
+			return
+		}
+
+		line := (*lines)[0]
+		if strings.ContainsAny(line, "\r\n\x00") {
+			t.Fatalf("line contains a line break: %q", line)
+		}
+		// Split the line the way RFC 1459 does: the trailing parameter starts
+		// at the first " :" and everything before it is a single space
+		// separated word each.
+		head, trailing := line, ""
+		if i := strings.Index(line, " :"); i >= 0 {
+			head, trailing = line[:i], line[i+2:]
+		}
+		var words []string
+		for _, w := range strings.Split(head, " ") {
+			if w != "" {
+				words = append(words, w)
+			}
+		}
+		if words[0] != "PRIVMSG" || len(words) > 2 {
+			t.Fatalf("injected a command or parameter: %q", line)
+		}
+		trimmed := strings.ToLower(strings.TrimLeftFunc(trailing, unicode.IsSpace))
+		for _, command := range chatCommands {
+			for _, prefix := range []string{"/", "."} {
+				if rest := strings.TrimPrefix(trimmed, prefix+command); rest != trimmed && (rest == "" || rest[0] == ' ') {
+					t.Fatalf("injected a chat command: %q", line)
+				}
+			}
+		}
+	})
+}
+
+// chatCommands are the chat commands Twitch runs when a message starts with
+// a slash or dot followed by one of them.
+var chatCommands = []string{
+	"ban", "unban", "timeout", "untimeout", "me", "w", "slow", "slowoff",
+	"clear", "mod", "unmod", "vip", "unvip", "host", "unhost", "raid",
+	"unraid", "commercial", "color", "followers", "followersoff",
+	"subscribers", "subscribersoff", "emoteonly", "emoteonlyoff", "r9kbeta",
+	"r9kbetaoff", "block", "unblock", "disconnect", "mods", "vips",
+	"marker", "delete", "help",
+}
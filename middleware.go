@@ -0,0 +1,162 @@
+package birc
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a Digester to add behaviour around it.
+type Middleware func(d Digester) Digester
+
+// Chain wraps d with the supplied middleware. The first middleware is the
+// outermost, so it sees each message first.
+func Chain(d Digester, middleware ...Middleware) Digester {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		d = middleware[i](d)
+	}
+	return d
+}
+
+// Recover stops a panicking digester from crashing the process. The recovered
+// value is passed to handler along with the message being digested; handler
+// may be nil.
+func Recover(handler func(r interface{}, m Message)) Middleware {
+	return func(d Digester) Digester {
+		return func(m Message, c ChannelWriter) {
+			defer func() {
+				if r := recover(); r != nil && handler != nil {
+					handler(r, m)
+				}
+			}()
+			d(m, c)
+		}
+	}
+}
+
+// Timing reports how long the digester took for every message, measured with
+// the ChannelWriter's Clock.
+func Timing(report func(m Message, elapsed time.Duration)) Middleware {
+	return func(d Digester) Digester {
+		return func(m Message, c ChannelWriter) {
+			clock := clockOf(c)
+			start := clock.Now()
+			d(m, c)
+			report(m, clock.Now().Sub(start))
+		}
+	}
+}
+
+// Filter only passes messages for which keep returns true.
+func Filter(keep func(m Message) bool) Middleware {
+	return func(d Digester) Digester {
+		return func(m Message, c ChannelWriter) {
+			if keep(m) {
+				d(m, c)
+			}
+		}
+	}
+}
+
+// FilterCommand only passes messages with one of the supplied IRC commands.
+func FilterCommand(commands ...string) Middleware {
+	return Filter(func(m Message) bool {
+		return containsFold(commands, m.Command)
+	})
+}
+
+// FilterChannel only passes messages sent to one of the supplied channels. The
+// leading # is optional.
+func FilterChannel(channels ...string) Middleware {
+	names := make([]string, len(channels))
+	for i, ch := range channels {
+		names[i] = strings.TrimPrefix(ch, "#")
+	}
+	return Filter(func(m Message) bool {
+		return containsFold(names, strings.TrimPrefix(ByChannel(m), "#"))
+	})
+}
+
+// FilterUser only passes messages sent by one of the supplied users.
+func FilterUser(users ...string) Middleware {
+	return Filter(func(m Message) bool {
+		return containsFold(users, ByUser(m))
+	})
+}
+
+// Sample passes a random fraction of messages, where rate is between 0 and 1.
+func Sample(rate float64) Middleware {
+	return Filter(func(m Message) bool {
+		return rand.Float64() < rate
+	})
+}
+
+// Deadline stops waiting for the digester after timeout and calls expired with the
+// message. The digester itself keeps running in the background since it can't be
+// interrupted, but it no longer holds up the dispatcher. The timeout is measured
+// with the ChannelWriter's Clock.
+//
+// A panic in the digester is raised again in the caller's goroutine, so Recover
+// placed outside Deadline still sees it. Once the deadline has expired nothing is
+// waiting to recover it, so a later panic is reported to the ChannelWriter if it
+// is an ErrorReporter instead of crashing the process.
+func Deadline(timeout time.Duration, expired func(m Message)) Middleware {
+	return func(d Digester) Digester {
+		return func(m Message, c ChannelWriter) {
+			var mu sync.Mutex
+			waiting := true
+			panicked := make(chan interface{}, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer func() {
+					r := recover()
+					if r == nil {
+						return
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if waiting {
+						panicked <- r
+					} else if reporter, ok := c.(ErrorReporter); ok {
+						reporter.ReportError(fmt.Errorf("birc: digester panicked after its deadline: %v", r), m)
+					}
+				}()
+				d(m, c)
+			}()
+
+			timedOut := make(chan struct{})
+			timer := clockOf(c).AfterFunc(timeout, func() { close(timedOut) })
+			defer timer.Stop()
+			late := false
+			select {
+			case <-done:
+			case <-timedOut:
+				mu.Lock()
+				waiting, late = false, true
+				mu.Unlock()
+			}
+
+			// The digester may have panicked just before the deadline.
+			select {
+			case r := <-panicked:
+				panic(r)
+			default:
+			}
+			if late && expired != nil {
+				expired(m)
+			}
+		}
+	}
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package birc_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) birc.Middleware {
+		return func(d birc.Digester) birc.Digester {
+			return func(m birc.Message, c birc.ChannelWriter) {
+				calls = append(calls, name)
+				d(m, c)
+			}
+		}
+	}
+
+	d := birc.Chain(func(m birc.Message, c birc.ChannelWriter) {
+		calls = append(calls, "digester")
+	}, mark("outer"), mark("inner"))
+	d(chat("bob", "hi"), nil)
+
+	expected := []string{"outer", "inner", "digester"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+	for i := range calls {
+		if calls[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var recovered interface{}
+	d := birc.Chain(func(m birc.Message, c birc.ChannelWriter) {
+		panic("boom")
+	}, birc.Recover(func(r interface{}, m birc.Message) {
+		recovered = r
+	}))
+	d(chat("bob", "hi"), nil)
+
+	if recovered != "boom" {
+		t.Errorf("expected to recover boom, got %v", recovered)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	var count int
+	d := birc.Chain(func(m birc.Message, c birc.ChannelWriter) {
+		count++
+	}, birc.FilterCommand("privmsg"), birc.FilterChannel("test"), birc.FilterUser("bob"))
+
+	d(chat("bob", "hi"), nil)
+	d(chat("alice", "hi"), nil)
+	d(birc.Message{Command: "JOIN", Username: "bob", Params: []string{"#test"}}, nil)
+	d(birc.Message{Command: "PRIVMSG", Username: "bob", Params: []string{"#other"}}, nil)
+
+	if count != 1 {
+		t.Errorf("expected 1 message to pass the filters, got %d", count)
+	}
+}
+
+func TestSample(t *testing.T) {
+	var count int
+	digester := func(m birc.Message, c birc.ChannelWriter) { count++ }
+
+	none, all := birc.Chain(digester, birc.Sample(0)), birc.Chain(digester, birc.Sample(1))
+	for i := 0; i < 10; i++ {
+		none(chat("bob", "hi"), nil)
+		all(chat("bob", "hi"), nil)
+	}
+
+	if count != 10 {
+		t.Errorf("expected 10 sampled messages, got %d", count)
+	}
+}
+
+func TestDeadlineAndTiming(t *testing.T) {
+	expired := make(chan bool, 1)
+	var elapsed time.Duration
+	d := birc.Chain(func(m birc.Message, c birc.ChannelWriter) {
+		time.Sleep(time.Second)
+	}, birc.Timing(func(m birc.Message, d time.Duration) {
+		elapsed = d
+	}), birc.Deadline(10*time.Millisecond, func(m birc.Message) {
+		expired <- true
+	}))
+	d(chat("bob", "hi"), nil)
+
+	select {
+	case <-expired:
+	default:
+		t.Error("expected the deadline to expire")
+	}
+	if elapsed >= time.Second {
+		t.Errorf("expected the deadline to stop waiting, took %s", elapsed)
+	}
+}
+
+func TestRecoverOutsideDeadline(t *testing.T) {
+	var recovered interface{}
+	d := birc.Chain(func(m birc.Message, c birc.ChannelWriter) {
+		panic("boom")
+	}, birc.Recover(func(r interface{}, m birc.Message) {
+		recovered = r
+	}), birc.FilterCommand("PRIVMSG"), birc.Deadline(time.Second, nil))
+	d(chat("bob", "hi"), nil)
+
+	if recovered != "boom" {
+		t.Errorf("expected to recover boom, got %v", recovered)
+	}
+}
+
+type reporter struct {
+	*birctest.Recorder
+	errs chan error
+}
+
+func (r reporter) ReportError(err error, m birc.Message) {
+	r.errs <- err
+}
+
+func TestDeadlineReportsLatePanics(t *testing.T) {
+	release := make(chan bool)
+	d := birc.Deadline(10*time.Millisecond, nil)(func(m birc.Message, c birc.ChannelWriter) {
+		<-release
+		panic("late")
+	})
+	w := reporter{birctest.NewRecorder("test", "foobar"), make(chan error, 1)}
+	d(chat("bob", "hi"), w)
+	close(release)
+
+	select {
+	case err := <-w.errs:
+		if !strings.Contains(err.Error(), "late") {
+			t.Errorf("expected the panic to be reported, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the late panic to be reported")
+	}
+}
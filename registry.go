@@ -0,0 +1,64 @@
+package birc
+
+import "sync"
+
+// Handle identifies digesters added with Register.
+type Handle uint64
+
+type registration struct {
+	handle    Handle
+	digesters []Digester
+}
+
+type registry struct {
+	sync.RWMutex
+	next    Handle
+	entries []registration
+}
+
+// Register adds digesters to a Channel, which may already be listening. The
+// returned Handle removes them again with Unregister, which makes it possible
+// to swap features in and out without reconnecting.
+func (c *Channel) Register(digesters ...Digester) Handle {
+	c.registry.Lock()
+	defer c.registry.Unlock()
+
+	c.registry.next++
+	c.registry.entries = append(c.registry.entries, registration{
+		handle:    c.registry.next,
+		digesters: digesters,
+	})
+	return c.registry.next
+}
+
+// Unregister removes the digesters added with h. Messages that were already
+// dispatched may still reach them. It reports whether h was registered.
+func (c *Channel) Unregister(h Handle) bool {
+	c.registry.Lock()
+	defer c.registry.Unlock()
+
+	for i, r := range c.registry.entries {
+		if r.handle == h {
+			c.registry.entries = append(c.registry.entries[:i], c.registry.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// digesters returns the Channel's digesters followed by the registered ones.
+func (c *Channel) digesters() []Digester {
+	c.registry.RLock()
+	defer c.registry.RUnlock()
+
+	if len(c.registry.entries) == 0 {
+		return c.Digesters
+	}
+
+	ds := make([]Digester, 0, len(c.Digesters)+len(c.registry.entries))
+	ds = append(ds, c.Digesters...)
+	for _, r := range c.registry.entries {
+		ds = append(ds, r.digesters...)
+	}
+	return ds
+}
@@ -0,0 +1,43 @@
+package birc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestRegisterAndUnregister(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+
+	received := make(chan string, 1)
+	h := c.Register(func(m birc.Message, w birc.ChannelWriter) {
+		received <- m.Content
+	})
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :first\r\n"))
+	select {
+	case content := <-received:
+		if content != "first" {
+			t.Errorf("expected first, got %s", content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the registered digester to receive the message")
+	}
+
+	if !c.Unregister(h) {
+		t.Error("expected Unregister to find the handle")
+	}
+	if c.Unregister(h) {
+		t.Error("expected the handle to be gone")
+	}
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :second\r\n"))
+	select {
+	case content := <-received:
+		t.Errorf("expected no message after Unregister, got %s", content)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
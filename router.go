@@ -0,0 +1,361 @@
+package birc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	sirc "github.com/sorcix/irc"
+)
+
+// Permission is the level of trust a chat user has in a channel.
+type Permission int
+
+const (
+	// Everyone includes all users.
+	Everyone Permission = iota
+	// Subscriber includes subscribers and founders.
+	Subscriber
+	// VIP includes users with the VIP badge.
+	VIP
+	// Moderator includes the channel's moderators.
+	Moderator
+	// Broadcaster is the channel owner.
+	Broadcaster
+)
+
+// PermissionOf returns the highest permission the message's sender holds,
+// based on their badges.
+func PermissionOf(m Message) Permission {
+	badges := m.Badges()
+	switch {
+	case badges["broadcaster"] != "":
+		return Broadcaster
+	case badges["moderator"] != "" || m.Tags["mod"] == "1":
+		return Moderator
+	case badges["vip"] != "":
+		return VIP
+	case badges["subscriber"] != "" || badges["founder"] != "" || m.Tags["subscriber"] == "1":
+		return Subscriber
+	}
+
+	// Without tags the broadcaster can still be recognised by name.
+	if m.Tags == nil && m.Username != "" && strings.EqualFold("#"+m.Username, ByChannel(m)) {
+		return Broadcaster
+	}
+	return Everyone
+}
+
+// ArgType is the type an argument is converted to before it reaches a handler.
+type ArgType int
+
+const (
+	// ArgString is a single word or quoted string.
+	ArgString ArgType = iota
+	// ArgInt is an integer.
+	ArgInt
+	// ArgFloat is a floating point number.
+	ArgFloat
+	// ArgBool is anything strconv.ParseBool accepts.
+	ArgBool
+	// ArgRest consumes the rest of the message as it was typed. It must be the
+	// last argument.
+	ArgRest
+)
+
+// Arg describes a command argument.
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+}
+
+// Args contains a command's parsed arguments.
+type Args struct {
+	values map[string]interface{}
+	// Raw contains the tokens as typed, after quotes were removed.
+	Raw []string
+}
+
+// Has reports whether the named argument was supplied.
+func (a Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns a string argument, or an empty string if it wasn't supplied.
+func (a Args) String(name string) string {
+	v, _ := a.values[name].(string)
+	return v
+}
+
+// Int returns an integer argument, or zero if it wasn't supplied.
+func (a Args) Int(name string) int {
+	v, _ := a.values[name].(int)
+	return v
+}
+
+// Float returns a floating point argument, or zero if it wasn't supplied.
+func (a Args) Float(name string) float64 {
+	v, _ := a.values[name].(float64)
+	return v
+}
+
+// Bool returns a boolean argument, or false if it wasn't supplied.
+func (a Args) Bool(name string) bool {
+	v, _ := a.values[name].(bool)
+	return v
+}
+
+// CommandHandler handles a chat command. Like a Digester it must be thread safe.
+type CommandHandler func(m Message, args Args, c ChannelWriter)
+
+// Command is a chat command registered with a Router.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Args        []Arg
+	// Permission is the lowest permission allowed to run the command.
+	Permission Permission
+	// Cooldown optionally limits how often the command can be used.
+	Cooldown *Cooldown
+	Handler  CommandHandler
+}
+
+// Router is a digester that parses chat messages into commands such as
+// "!so bob" and calls their handlers. A help command listing the commands
+// available to the user is built in, unless a command named help is registered.
+type Router struct {
+	prefix   string
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewRouter creates a Router for commands starting with prefix, e.g. "!".
+func NewRouter(prefix string) *Router {
+	return &Router{prefix: prefix, commands: map[string]*Command{}}
+}
+
+// Handle registers a command. It returns an error if the command's name or any
+// of its aliases is already taken.
+func (r *Router) Handle(cmd Command) error {
+	if cmd.Name == "" || cmd.Handler == nil {
+		return errors.New("birc: command needs a name and a handler")
+	}
+	for i, arg := range cmd.Args {
+		if arg.Type == ArgRest && i != len(cmd.Args)-1 {
+			return fmt.Errorf("birc: rest argument %s must be last", arg.Name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := r.commands[strings.ToLower(name)]; ok {
+			return fmt.Errorf("birc: command %s already registered", name)
+		}
+	}
+	for _, name := range names {
+		r.commands[strings.ToLower(name)] = &cmd
+	}
+	return nil
+}
+
+// Digest is the Router's Digester, pass it to NewTwitchChannel.
+func (r *Router) Digest(m Message, c ChannelWriter) {
+	if m.Command != sirc.PRIVMSG || !strings.HasPrefix(m.Content, r.prefix) {
+		return
+	}
+
+	line := strings.TrimPrefix(m.Content, r.prefix)
+	name, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, rest = line[:i], strings.TrimLeft(line[i:], " \t")
+	}
+	name = strings.ToLower(name)
+
+	r.mu.RLock()
+	cmd, ok := r.commands[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		if name == "help" {
+			r.help(m, rest, c)
+		}
+		return
+	}
+	if PermissionOf(m) < cmd.Permission {
+		return
+	}
+
+	args, err := parseArgs(cmd.Args, rest)
+	if err != nil {
+		c.Send(fmt.Sprintf("Usage: %s", r.Usage(cmd)))
+		return
+	}
+	if cmd.Cooldown != nil && !cmd.Cooldown.check(m, c) {
+		return
+	}
+	cmd.Handler(m, args, c)
+}
+
+// Usage returns a usage line for the command, e.g. "!so <user> [message...]".
+func (r *Router) Usage(cmd *Command) string {
+	parts := []string{r.prefix + cmd.Name}
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Type == ArgRest {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Help returns the names of the commands available at permission p, e.g.
+// "Commands: !help, !so".
+func (r *Router) Help(p Permission) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{r.prefix + "help"}
+	for key, cmd := range r.commands {
+		if key == strings.ToLower(cmd.Name) && cmd.Permission <= p && key != "help" {
+			names = append(names, r.prefix+cmd.Name)
+		}
+	}
+	sort.Strings(names)
+	return "Commands: " + strings.Join(names, ", ")
+}
+
+func (r *Router) help(m Message, topic string, c ChannelWriter) {
+	p := PermissionOf(m)
+	if topic == "" {
+		c.Send(r.Help(p))
+		return
+	}
+
+	r.mu.RLock()
+	cmd, ok := r.commands[strings.ToLower(strings.TrimPrefix(topic, r.prefix))]
+	r.mu.RUnlock()
+	if !ok || cmd.Permission > p {
+		return
+	}
+
+	help := r.Usage(cmd)
+	if len(cmd.Aliases) > 0 {
+		help += " (aliases: " + strings.Join(cmd.Aliases, ", ") + ")"
+	}
+	if cmd.Description != "" {
+		help += " - " + cmd.Description
+	}
+	c.Send(help)
+}
+
+var errArgs = errors.New("birc: invalid arguments")
+
+func parseArgs(spec []Arg, line string) (Args, error) {
+	args := Args{values: map[string]interface{}{}}
+	for _, arg := range spec {
+		if arg.Type == ArgRest {
+			line = strings.TrimLeft(line, " \t")
+			if line == "" {
+				if !arg.Optional {
+					return args, errArgs
+				}
+				break
+			}
+			args.values[arg.Name] = line
+			args.Raw = append(args.Raw, line)
+			line = ""
+			break
+		}
+
+		token, rest, err := nextToken(line)
+		if err != nil {
+			return args, err
+		}
+		line = rest
+		if token == nil {
+			if !arg.Optional {
+				return args, errArgs
+			}
+			continue
+		}
+		args.Raw = append(args.Raw, *token)
+
+		var v interface{} = *token
+		switch arg.Type {
+		case ArgInt:
+			v, err = strconv.Atoi(*token)
+		case ArgFloat:
+			v, err = strconv.ParseFloat(*token, 64)
+		case ArgBool:
+			v, err = strconv.ParseBool(*token)
+		}
+		if err != nil {
+			return args, errArgs
+		}
+		args.values[arg.Name] = v
+	}
+
+	// Keep any extra tokens available to the handler.
+	for line != "" {
+		token, rest, err := nextToken(line)
+		if err != nil {
+			return args, err
+		}
+		if token == nil {
+			break
+		}
+		args.Raw = append(args.Raw, *token)
+		line = rest
+	}
+	return args, nil
+}
+
+// nextToken splits the first word or double quoted string off line. Inside
+// quotes a backslash escapes the next character. It returns a nil token when
+// line is empty.
+func nextToken(line string) (*string, string, error) {
+	line = strings.TrimLeft(line, " \t")
+	if line == "" {
+		return nil, "", nil
+	}
+
+	if line[0] != '"' {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return &line, "", nil
+		}
+		token := line[:i]
+		return &token, line[i:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if i+1 < len(line) {
+				i++
+				b.WriteByte(line[i])
+			}
+		case '"':
+			token := b.String()
+			return &token, line[i+1:], nil
+		default:
+			b.WriteByte(line[i])
+		}
+	}
+	return nil, "", errArgs
+}
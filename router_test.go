@@ -0,0 +1,117 @@
+package birc_test
+
+import (
+	"testing"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+// replies is a ChannelWriter that keeps everything sent through it.
+type replies []string
+
+func (r *replies) Send(content string) error {
+	*r = append(*r, content)
+	return nil
+}
+
+func (r *replies) SendMessage(m *birc.Message) error {
+	return r.Send(m.Content)
+}
+
+func (r *replies) GetConfig() birc.Config {
+	return birc.Config{ChannelName: "test", Username: "foobar"}
+}
+
+func privmsg(t *testing.T, badges, content string) birc.Message {
+	m := birc.ParseMessage("@badges=" + badges + ";display-name=Bob :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :" + content)
+	if m == nil {
+		t.Fatalf("failed to parse %s", content)
+	}
+	return *m
+}
+
+func TestPermissionOf(t *testing.T) {
+	for badges, expected := range map[string]birc.Permission{
+		"":                           birc.Everyone,
+		"subscriber/12":              birc.Subscriber,
+		"founder/0":                  birc.Subscriber,
+		"vip/1":                      birc.VIP,
+		"moderator/1,subscriber/12":  birc.Moderator,
+		"broadcaster/1,subscriber/0": birc.Broadcaster,
+	} {
+		if p := birc.PermissionOf(privmsg(t, badges, "hi")); p != expected {
+			t.Errorf("expected permission %d for %q, got %d", expected, badges, p)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := birc.NewRouter("!")
+
+	var user, message string
+	var count int
+	err := r.Handle(birc.Command{
+		Name:        "shoutout",
+		Aliases:     []string{"so"},
+		Description: "Shout out a streamer",
+		Permission:  birc.Moderator,
+		Args: []birc.Arg{
+			{Name: "user", Type: birc.ArgString},
+			{Name: "count", Type: birc.ArgInt, Optional: true},
+			{Name: "message", Type: birc.ArgRest, Optional: true},
+		},
+		Handler: func(m birc.Message, args birc.Args, c birc.ChannelWriter) {
+			user, count, message = args.String("user"), args.Int("count"), args.String("message")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Handle(birc.Command{Name: "SO", Handler: func(birc.Message, birc.Args, birc.ChannelWriter) {}}); err == nil {
+		t.Error("expected an error registering a taken alias")
+	}
+
+	var w replies
+	r.Digest(privmsg(t, "", "!so alice"), &w)
+	if user != "" {
+		t.Error("expected the command to require a moderator")
+	}
+
+	r.Digest(privmsg(t, "moderator/1", `!SO "alice smith" 3 go   follow her`), &w)
+	if user != "alice smith" || count != 3 || message != "go   follow her" {
+		t.Errorf("unexpected arguments %q %d %q", user, count, message)
+	}
+
+	r.Digest(privmsg(t, "moderator/1", "!so alice three"), &w)
+	if len(w) != 1 || w[0] != "Usage: !shoutout <user> [count] [message...]" {
+		t.Errorf("expected usage reply, got %v", w)
+	}
+}
+
+func TestRouterHelp(t *testing.T) {
+	r := birc.NewRouter("!")
+	noop := func(birc.Message, birc.Args, birc.ChannelWriter) {}
+	r.Handle(birc.Command{Name: "uptime", Description: "How long the stream has been live", Handler: noop})
+	r.Handle(birc.Command{Name: "ban", Permission: birc.Moderator, Args: []birc.Arg{{Name: "user"}}, Handler: noop})
+
+	var w replies
+	r.Digest(privmsg(t, "", "!help"), &w)
+	r.Digest(privmsg(t, "moderator/1", "!help"), &w)
+	r.Digest(privmsg(t, "", "!help uptime"), &w)
+	r.Digest(privmsg(t, "", "!help ban"), &w)
+
+	expected := []string{
+		"Commands: !help, !uptime",
+		"Commands: !ban, !help, !uptime",
+		"!uptime - How long the stream has been live",
+	}
+	if len(w) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, w)
+	}
+	for i := range expected {
+		if w[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], w[i])
+		}
+	}
+}
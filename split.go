@@ -0,0 +1,178 @@
+package birc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SendLong sends content to the channel, split into as many chat messages as
+// needed to respect MaxMessageLength. Each part is also kept within the bytes
+// left for the content of an IRC line of MaxLineLength, which multibyte text
+// such as emoji and CJK fills well before MaxMessageLength characters. Parts are
+// split between words, so emotes are never broken up; only words longer than a
+// whole message are split, and then only between grapheme clusters. If numbered
+// is true each part ends with its position, e.g. "(1/3)". Parts are sent in
+// order and sending stops at the first error.
+//
+// The Channel doesn't rate limit its sends, so neither does SendLong: the parts
+// are sent back to back, and Twitch drops those over its rate limit with an
+// ErrRateLimited notice.
+func (c *Channel) SendLong(content string, numbered bool) error {
+	// The content shares the line with the prefix, command and channel.
+	overhead := c.chat(".").sanitized().prepare().Len() - len(".")
+	for _, part := range split(content, limit{MaxMessageLength, MaxLineLength - overhead}, numbered) {
+		if err := c.Send(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split splits content into parts of at most limit characters, between words
+// the way SendLong does.
+func Split(content string, limit int, numbered bool) []string {
+	return split(content, characters(limit), numbered)
+}
+
+// limit is the most characters and bytes a part may have.
+type limit struct {
+	runes, bytes int
+}
+
+// characters limits parts to n characters, however many bytes they take.
+func characters(n int) limit {
+	return limit{n, math.MaxInt}
+}
+
+func (l limit) fits(runes, bytes int) bool {
+	return runes <= l.runes && bytes <= l.bytes
+}
+
+func split(content string, l limit, numbered bool) []string {
+	words := strings.Fields(content)
+	if !numbered {
+		return pack(words, l)
+	}
+
+	// The suffix's length depends on the number of parts, so pack again until
+	// the number of digits reserved for it is enough.
+	for digits := 1; ; digits++ {
+		reserved := len(" (/)") + 2*digits
+		if !l.fits(reserved, reserved) {
+			return pack(words, l)
+		}
+		parts := pack(words, limit{l.runes - reserved, l.bytes - reserved})
+		if len(fmt.Sprint(len(parts))) <= digits {
+			for i := range parts {
+				parts[i] = fmt.Sprintf("%s (%d/%d)", parts[i], i+1, len(parts))
+			}
+			return parts
+		}
+	}
+}
+
+// pack greedily fills parts within l with words.
+func pack(words []string, l limit) []string {
+	var parts []string
+	var part strings.Builder
+	length := 0
+
+	flush := func() {
+		if length > 0 {
+			parts = append(parts, part.String())
+			part.Reset()
+			length = 0
+		}
+	}
+
+	for _, word := range words {
+		n := utf8.RuneCountInString(word)
+		if !l.fits(n, len(word)) {
+			flush()
+			chunks := splitGraphemes(word, l)
+			parts = append(parts, chunks[:len(chunks)-1]...)
+			word = chunks[len(chunks)-1]
+			n = utf8.RuneCountInString(word)
+		} else if length > 0 && !l.fits(length+1+n, part.Len()+1+len(word)) {
+			flush()
+		}
+
+		if length > 0 {
+			part.WriteByte(' ')
+			length++
+		}
+		part.WriteString(word)
+		length += n
+	}
+	flush()
+	return parts
+}
+
+// splitGraphemes splits s into chunks within l without breaking grapheme
+// clusters. A single cluster larger than l gets a chunk of its own.
+func splitGraphemes(s string, l limit) []string {
+	var chunks []string
+	var chunk strings.Builder
+	length := 0
+	for _, g := range graphemes(s) {
+		n := utf8.RuneCountInString(g)
+		if length > 0 && !l.fits(length+n, chunk.Len()+len(g)) {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+			length = 0
+		}
+		chunk.WriteString(g)
+		length += n
+	}
+	return append(chunks, chunk.String())
+}
+
+// graphemes splits s into user-perceived characters. It approximates the
+// Unicode segmentation rules well enough for chat: combining marks, variation
+// selectors, emoji modifiers, zero width joiner sequences and flag pairs stay
+// attached to the character before them.
+func graphemes(s string) []string {
+	var clusters []string
+	start, prev, flags := 0, rune(-1), 0
+	for i, r := range s {
+		if i > 0 && !extends(prev, r, flags) {
+			clusters = append(clusters, s[start:i])
+			start, flags = i, 0
+		}
+		if isRegionalIndicator(r) {
+			flags++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// extends reports whether r continues the cluster ending in prev, which
+// contains flags regional indicators.
+func extends(prev, r rune, flags int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == zeroWidthJoiner || r == zeroWidthJoiner:
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0x1f3fb && r <= 0x1f3ff, r >= 0xe0020 && r <= 0xe007f:
+		return true
+	case isRegionalIndicator(r) && isRegionalIndicator(prev):
+		return flags%2 == 1
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,114 @@
+package birc_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestSplitOnWords(t *testing.T) {
+	parts := birc.Split("Kappa hello there PogChamp world", 16, false)
+	expected := []string{"Kappa hello", "there PogChamp", "world"}
+	if strings.Join(parts, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, parts)
+	}
+}
+
+func TestSplitNumbered(t *testing.T) {
+	parts := birc.Split(strings.Repeat("word ", 30), 30, true)
+	for i, part := range parts {
+		if utf8.RuneCountInString(part) > 30 {
+			t.Errorf("part %d is too long: %q", i, part)
+		}
+	}
+	if len(parts) != 6 || !strings.HasSuffix(parts[0], " (1/6)") || !strings.HasSuffix(parts[5], " (6/6)") {
+		t.Errorf("unexpected parts %q", parts)
+	}
+}
+
+func TestSplitKeepsGraphemes(t *testing.T) {
+	accent := "e\u0301"
+	family := "\U0001F468\u200D\U0001F469\u200D\U0001F467"
+	flag := "\U0001F1F3\U0001F1F1"
+	word := strings.Repeat(accent, 3) + family + flag + flag
+
+	for limit := 1; limit < 12; limit++ {
+		parts := birc.Split(word, limit, false)
+		if strings.Join(parts, "") != word {
+			t.Fatalf("expected the parts to add up to the word, got %q", parts)
+		}
+		for _, part := range parts {
+			if strings.HasPrefix(part, "\u0301") || strings.HasPrefix(part, "\u200D") ||
+				strings.HasPrefix(part, "\U0001F1F1") || strings.HasSuffix(part, "\U0001F1F3") {
+				t.Errorf("limit %d split a grapheme: %q", limit, parts)
+			}
+		}
+	}
+}
+
+func TestSendLong(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = server.Addr
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown(context.Background())
+
+	tests := []struct {
+		content  string
+		numbered bool
+		parts    int
+	}{
+		{strings.Repeat("Kappa ", 200), false, 3},
+		{strings.Repeat("Kappa ", 200), true, 3},
+		{strings.Repeat("🎉", 300), false, 3},
+		{strings.Repeat("你好世界 ", 150), true, 5},
+	}
+	received := 0
+	for i, test := range tests {
+		if err := c.SendLong(test.content, test.numbered); err != nil {
+			t.Fatal(err)
+		}
+		// Lines arrive in order, so every part is in once this one is.
+		done := fmt.Sprintf("done %d", i)
+		c.Send(done)
+		if _, err := server.WaitFor(timeout(t), func(line string) bool { return strings.HasSuffix(line, ":"+done) }); err != nil {
+			t.Fatal(err)
+		}
+		lines := server.Received()
+		lines, received = lines[received:len(lines)-1], len(lines)
+
+		var contents []string
+		for _, line := range lines {
+			if len(line) > birc.MaxLineLength {
+				t.Errorf("expected lines of at most %d bytes, got %d", birc.MaxLineLength, len(line))
+			}
+			content := birc.ParseMessage(line).Content
+			if utf8.RuneCountInString(content) > birc.MaxMessageLength || strings.Contains(content, "Kap ") {
+				t.Errorf("unexpected message %q", content)
+			}
+			if test.numbered {
+				suffix := fmt.Sprintf(" (%d/%d)", len(contents)+1, len(lines))
+				if !strings.HasSuffix(content, suffix) {
+					t.Errorf("expected %q to end with %q", content, suffix)
+				}
+				content = strings.TrimSuffix(content, suffix)
+			}
+			contents = append(contents, content)
+		}
+		if len(lines) != test.parts {
+			t.Errorf("expected %d parts, got %d", test.parts, len(lines))
+		}
+		if strings.ReplaceAll(strings.Join(contents, ""), " ", "") != strings.ReplaceAll(test.content, " ", "") {
+			t.Errorf("expected the parts to add up to the content, got %q", contents)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package birc
+
+import "sync"
+
+// State describes where a Channel is in its connection lifecycle.
+type State int
+
+const (
+	// StateDisconnected is the state of a Channel that has never connected.
+	StateDisconnected State = iota
+	// StateConnecting means the TCP connection is being established.
+	StateConnecting
+	// StateConnected means the TCP connection is open but no credentials were sent.
+	StateConnected
+	// StateAuthenticating means PASS and NICK were sent and the server hasn't replied.
+	StateAuthenticating
+	// StateAuthenticated means the server accepted the credentials.
+	StateAuthenticated
+	// StateJoined means the server confirmed the JOIN of the configured channel.
+	StateJoined
+	// StateReconnecting means the Channel is re-establishing its connection.
+	StateReconnecting
+	// StateClosed means the listener has stopped and the connection is closed.
+	StateClosed
+)
+
+var stateNames = map[State]string{
+	StateDisconnected:   "disconnected",
+	StateConnecting:     "connecting",
+	StateConnected:      "connected",
+	StateAuthenticating: "authenticating",
+	StateAuthenticated:  "authenticated",
+	StateJoined:         "joined",
+	StateReconnecting:   "reconnecting",
+	StateClosed:         "closed",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Hook is a lifecycle callback. Hooks are called synchronously from the goroutine
+// that caused the transition, so they should not block.
+type Hook func(c *Channel)
+
+// DisconnectHook is called when the Channel stops listening, with the error that
+// ended the listener or nil for a clean shutdown.
+type DisconnectHook func(c *Channel, err error)
+
+type hooks struct {
+	sync.Mutex
+	connect       []Hook
+	authenticated []Hook
+	join          []Hook
+	reconnect     []Hook
+	disconnect    []DisconnectHook
+}
+
+// State returns the Channel's current lifecycle state. It is safe to call from
+// any goroutine.
+func (c *Channel) State() State {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.state
+}
+
+func (c *Channel) setState(s State) {
+	c.changeState(s, nil)
+}
+
+// changeState moves the Channel into state s and emits a StateChange event.
+func (c *Channel) changeState(s State, err error) {
+	c.stateMu.Lock()
+	from := c.state
+	c.state = s
+	c.stateMu.Unlock()
+
+	if from != s || err != nil {
+		c.publishEvent(StateChange{From: from, To: s, Err: err})
+	}
+}
+
+// OnConnect registers a hook called after the TCP connection is established.
+func (c *Channel) OnConnect(h Hook) {
+	c.hooks.Lock()
+	c.hooks.connect = append(c.hooks.connect, h)
+	c.hooks.Unlock()
+}
+
+// OnAuthenticated registers a hook called when the server accepts the credentials.
+func (c *Channel) OnAuthenticated(h Hook) {
+	c.hooks.Lock()
+	c.hooks.authenticated = append(c.hooks.authenticated, h)
+	c.hooks.Unlock()
+}
+
+// OnJoin registers a hook called when the server confirms the channel JOIN.
+func (c *Channel) OnJoin(h Hook) {
+	c.hooks.Lock()
+	c.hooks.join = append(c.hooks.join, h)
+	c.hooks.Unlock()
+}
+
+// OnReconnect registers a hook called after a successful reconnect.
+func (c *Channel) OnReconnect(h Hook) {
+	c.hooks.Lock()
+	c.hooks.reconnect = append(c.hooks.reconnect, h)
+	c.hooks.Unlock()
+}
+
+// OnDisconnect registers a hook called when the listener stops.
+func (c *Channel) OnDisconnect(h DisconnectHook) {
+	c.hooks.Lock()
+	c.hooks.disconnect = append(c.hooks.disconnect, h)
+	c.hooks.Unlock()
+}
+
+// transition moves the Channel into state s and runs the hooks registered for it.
+func (c *Channel) transition(s State) {
+	c.setState(s)
+
+	c.hooks.Lock()
+	var hs []Hook
+	switch s {
+	case StateConnected:
+		hs = c.hooks.connect
+	case StateAuthenticated:
+		hs = c.hooks.authenticated
+	case StateJoined:
+		hs = c.hooks.join
+	}
+	c.hooks.Unlock()
+
+	for _, h := range hs {
+		h(c)
+	}
+}
+
+func (c *Channel) reconnected() {
+	c.hooks.Lock()
+	hs := c.hooks.reconnect
+	c.hooks.Unlock()
+
+	for _, h := range hs {
+		h(c)
+	}
+}
+
+// closed runs once the listener has stopped. It fires the disconnect hooks and
+// releases everything waiting on the Channel.
+func (c *Channel) closed(err error) {
+	c.cancelContext()
+	c.changeState(StateClosed, err)
+
+	c.hooks.Lock()
+	hs := c.hooks.disconnect
+	c.hooks.Unlock()
+
+	for _, h := range hs {
+		h(c, err)
+	}
+
+	c.release()
+	c.messages.close()
+	c.events.close()
+}
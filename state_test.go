@@ -0,0 +1,96 @@
+package birc_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestStateString(t *testing.T) {
+	if birc.StateJoined.String() != "joined" {
+		t.Errorf("expected joined, got %s", birc.StateJoined)
+	}
+	if birc.State(100).String() != "unknown" {
+		t.Errorf("expected unknown, got %s", birc.State(100))
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := &birc.Channel{Config: &birc.Config{
+		ChannelName: "test",
+		Username:    "foobar",
+		OAuthToken:  "abc123",
+		Server:      l.Addr().String(),
+	}}
+
+	if c.State() != birc.StateDisconnected {
+		t.Errorf("expected disconnected, got %s", c.State())
+	}
+
+	events := make(chan string, 4)
+	c.OnConnect(func(c *birc.Channel) { events <- "connect" })
+	c.OnAuthenticated(func(c *birc.Channel) { events <- "authenticated" })
+	c.OnJoin(func(c *birc.Channel) { events <- "join" })
+	c.OnDisconnect(func(c *birc.Channel, err error) {
+		if err == nil {
+			t.Error("expected disconnect error")
+		}
+		events <- "disconnect"
+	})
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != birc.StateConnected {
+		t.Errorf("expected connected, got %s", c.State())
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != birc.StateAuthenticating {
+		t.Errorf("expected authenticating, got %s", c.State())
+	}
+
+	go c.Listen()
+
+	conn.Write([]byte(":tmi.twitch.tv 001 foobar :Welcome, GLHF!\r\n"))
+	conn.Write([]byte(":foobar!foobar@foobar.tmi.twitch.tv JOIN #test\r\n"))
+	expectHooks(t, events, "connect", "authenticated", "join")
+	if c.State() != birc.StateJoined {
+		t.Errorf("expected joined, got %s", c.State())
+	}
+
+	conn.Close()
+	expectHooks(t, events, "disconnect")
+
+	if c.State() != birc.StateClosed {
+		t.Errorf("expected closed, got %s", c.State())
+	}
+}
+
+func expectHooks(t *testing.T, events chan string, expected ...string) {
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			if got != e {
+				t.Errorf("expected %s hook, got %s", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s hook", e)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package birc
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats contains counters describing a Channel's connection history.
+type Stats struct {
+	// Reconnects counts reconnects requested by the server.
+	Reconnects uint64
+	// ForcedReconnects counts reconnects forced by the silence watchdog.
+	ForcedReconnects uint64
+	// Errors counts errors that ended the listener.
+	Errors uint64
+	// Dropped counts messages and events discarded by full streams.
+	Dropped uint64
+	// LastInbound is the time the last line was received.
+	LastInbound time.Time
+}
+
+type stats struct {
+	sync.Mutex
+	Stats
+}
+
+// Stats returns a snapshot of the Channel's counters. It is safe to call from
+// any goroutine.
+func (c *Channel) Stats() Stats {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	return c.stats.Stats
+}
+
+func (c *Channel) count(f func(s *Stats)) {
+	c.stats.Lock()
+	f(&c.stats.Stats)
+	c.stats.Unlock()
+}
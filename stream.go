@@ -0,0 +1,117 @@
+package birc
+
+import "sync"
+
+// stream fans values out to subscribed Go channels.
+type stream[T any] struct {
+	mu     sync.RWMutex
+	subs   []subscription[T]
+	closed bool
+}
+
+type subscription[T any] struct {
+	ch     chan T
+	policy OverflowPolicy
+}
+
+func (s *stream[T]) subscribe(buffer int, policy OverflowPolicy) <-chan T {
+	if buffer < 0 {
+		buffer = 0
+	}
+	// Dropping the oldest value needs room to hold the newest one.
+	if policy == OverflowDropOldest && buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan T, buffer)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(ch)
+		return ch
+	}
+	s.subs = append(s.subs, subscription[T]{ch, policy})
+	return ch
+}
+
+// publish delivers v to every subscriber and returns how many values were
+// dropped to do so.
+func (s *stream[T]) publish(v T) (dropped uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sub := range s.subs {
+		switch sub.policy {
+		case OverflowDropNewest:
+			select {
+			case sub.ch <- v:
+			default:
+				dropped++
+			}
+		case OverflowDropOldest:
+			for sent := false; !sent; {
+				select {
+				case sub.ch <- v:
+					sent = true
+				default:
+					select {
+					case <-sub.ch:
+						dropped++
+					default:
+					}
+				}
+			}
+		default:
+			sub.ch <- v
+		}
+	}
+	return dropped
+}
+
+func (s *stream[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		close(sub.ch)
+	}
+	s.subs = nil
+	s.closed = true
+}
+
+// reopen lets a closed stream take subscribers again.
+func (s *stream[T]) reopen() {
+	s.mu.Lock()
+	s.closed = false
+	s.mu.Unlock()
+}
+
+// Messages returns a Go channel that receives every message the digesters do,
+// in the order it was received. Up to buffer messages are held for a slow
+// reader; after that policy decides whether the listener waits or a message is
+// dropped. OverflowDropOldest holds at least one message. Dropped messages are
+// counted in Stats. The Go channel is closed when the listener stops; after
+// connecting again, Messages returns a new one.
+func (c *Channel) Messages(buffer int, policy OverflowPolicy) <-chan Message {
+	return c.messages.subscribe(buffer, policy)
+}
+
+// Events returns a Go channel that receives the Channel's events. It buffers
+// and drops events like Messages and is closed when the listener stops. Events
+// are published by whichever goroutine causes them, so with OverflowBlock a
+// slow reader holds up the listener and also Connect, Authenticate, Reconnect
+// and Shutdown, which publish StateChange events.
+func (c *Channel) Events(buffer int, policy OverflowPolicy) <-chan Event {
+	return c.events.subscribe(buffer, policy)
+}
+
+func (c *Channel) publishMessage(m Message) {
+	if dropped := c.messages.publish(m); dropped > 0 {
+		c.count(func(s *Stats) { s.Dropped += dropped })
+	}
+}
+
+func (c *Channel) publishEvent(e Event) {
+	if dropped := c.events.publish(e); dropped > 0 {
+		c.count(func(s *Stats) { s.Dropped += dropped })
+	}
+}
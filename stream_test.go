@@ -0,0 +1,150 @@
+package birc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestMessagesStream(t *testing.T) {
+	c, conn := connected(t)
+	messages := c.Messages(10, birc.OverflowBlock)
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :one\r\n"))
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :two\r\n"))
+
+	for _, expected := range []string{"one", "two"} {
+		select {
+		case m := <-messages:
+			if m.Content != expected {
+				t.Errorf("expected %s, got %s", expected, m.Content)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+
+	c.Shutdown(context.Background())
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Error("expected the stream to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to close on shutdown")
+	}
+}
+
+func TestMessagesStreamDrops(t *testing.T) {
+	c, conn := connected(t)
+	defer c.Shutdown(context.Background())
+	newest := c.Messages(1, birc.OverflowDropNewest)
+	oldest := c.Messages(1, birc.OverflowDropOldest)
+
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :one\r\n"))
+	conn.Write([]byte(":bob!bob@bob.tmi.twitch.tv PRIVMSG #test :two\r\n"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.WaitFor(ctx, func(m birc.Message) bool { return m.Content == "two" })
+
+	if m := <-newest; m.Content != "one" {
+		t.Errorf("expected the newest message to be dropped, got %s", m.Content)
+	}
+	if m := <-oldest; m.Content != "two" {
+		t.Errorf("expected the oldest message to be dropped, got %s", m.Content)
+	}
+	if dropped := c.Stats().Dropped; dropped != 2 {
+		t.Errorf("expected 2 drops, got %d", dropped)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	c, conn := connected(t)
+	events := c.Events(10, birc.OverflowBlock)
+
+	conn.Write([]byte(":tmi.twitch.tv 001 foobar :Welcome, GLHF!\r\n"))
+	select {
+	case e := <-events:
+		change, ok := e.(birc.StateChange)
+		if !ok || change.From != birc.StateConnected || change.To != birc.StateAuthenticated {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	c.Shutdown(context.Background())
+}
+
+func TestUnbufferedDropOldest(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = server.Addr
+	events := c.Events(0, birc.OverflowDropOldest)
+
+	connected := make(chan error, 1)
+	go func() { connected <- c.Connect() }()
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Connect not to wait for the events reader")
+	}
+	defer c.Shutdown(context.Background())
+
+	if e := <-events; e.(birc.StateChange).To != birc.StateConnected {
+		t.Errorf("expected the newest event to be kept, got %+v", e)
+	}
+}
+
+func TestStreamsAfterReconnecting(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = server.Addr
+	joined := make(chan string, 1)
+	c.OnJoin(func(c *birc.Channel) { joined <- "join" })
+	listen := func() chan error {
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Authenticate(); err != nil {
+			t.Fatal(err)
+		}
+		listening := make(chan error, 1)
+		go func() { listening <- c.Listen() }()
+		expectHooks(t, joined, "join")
+		return listening
+	}
+
+	listening := listen()
+	messages := c.Messages(10, birc.OverflowBlock)
+	server.Drop()
+	if err := <-listening; err == nil {
+		t.Fatal("expected Listen to fail when the server drops the connection")
+	}
+	if _, ok := <-messages; ok {
+		t.Error("expected the stream to be closed when the listener stops")
+	}
+
+	// Connecting again starts new streams.
+	listen()
+	defer c.Shutdown(context.Background())
+	messages = c.Messages(10, birc.OverflowBlock)
+	server.Chat("test", "bob", "welcome back", nil)
+	select {
+	case m, ok := <-messages:
+		if !ok || m.Content != "welcome back" {
+			t.Errorf("expected the message on the new stream, got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message on the new stream")
+	}
+}
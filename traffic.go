@@ -0,0 +1,119 @@
+package birc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Traffic records start with the time the line was seen and its direction:
+// "<" for received and ">" for sent lines, e.g.
+//
+//	2017-10-05T23:36:12.675Z < :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi
+const (
+	trafficIn  = "<"
+	trafficOut = ">"
+)
+
+// RecordTraffic writes every raw line the Channel receives and sends to w, one
+// record per line, stamped with the Channel's Clock. Credentials are redacted.
+// Write errors are ignored so a failing recording never affects the Channel.
+func (c *Channel) RecordTraffic(w io.Writer) {
+	var mu sync.Mutex
+	record := func(direction string) LineHook {
+		return func(line string) {
+			now := c.Clock().Now().UTC().Format(time.RFC3339Nano)
+			mu.Lock()
+			fmt.Fprintf(w, "%s %s %s\n", now, direction, strings.TrimRight(line, "\r\n"))
+			mu.Unlock()
+		}
+	}
+	c.OnInbound(record(trafficIn))
+	c.OnOutbound(record(trafficOut))
+}
+
+// Replay reads traffic written by RecordTraffic and passes the received lines
+// through the Channel as if they had just arrived, without a connection.
+// Sent lines are skipped; whatever the digesters send goes to the Channel's
+// writer, so point it at a recorder with SetWriter first. Messages keep the
+// time they were originally received.
+//
+// Unless a Dispatcher was set with SetDispatcher, Replay digests the messages
+// one at a time, in the order they were recorded, without changing the
+// Channel's dispatcher. A Dispatcher that was set is used as is, so with
+// FireAndForget, for one, digesters see the messages in any order.
+//
+// With realtime set, Replay waits between lines as long as they were originally
+// apart, otherwise it goes as fast as possible. It returns once every replayed
+// message has been digested, or early with ctx's error.
+func (c *Channel) Replay(ctx context.Context, r io.Reader, realtime bool) error {
+	c.mu.Lock()
+	d := c.dispatcher
+	c.mu.Unlock()
+	if d == nil {
+		d = WorkerPool(1, 0, ByChannel, OverflowBlock)
+	}
+
+	scanner := bufio.NewScanner(r)
+
+	var last time.Time
+	for n := 1; scanner.Scan(); n++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) != 3 || (parts[1] != trafficIn && parts[1] != trafficOut) {
+			return fmt.Errorf("birc: invalid traffic record on line %d", n)
+		}
+		at, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return fmt.Errorf("birc: invalid traffic record on line %d: %v", n, err)
+		}
+		if parts[1] != trafficIn {
+			continue
+		}
+
+		if realtime && !last.IsZero() && at.After(last) {
+			if err := c.sleep(ctx, at.Sub(last)); err != nil {
+				return err
+			}
+		}
+		last = at
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m := c.receive(parts[2], at)
+		// Replies to the server would mean nothing offline.
+		if m == nil || m.Command == "PING" || m.Command == "RECONNECT" {
+			continue
+		}
+		if err := c.deliver(m, d); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	d.Wait()
+	return nil
+}
+
+// sleep waits for d on the Channel's Clock.
+func (c *Channel) sleep(ctx context.Context, d time.Duration) error {
+	elapsed := make(chan struct{})
+	timer := c.Clock().AfterFunc(d, func() { close(elapsed) })
+	defer timer.Stop()
+
+	select {
+	case <-elapsed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
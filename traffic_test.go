@@ -0,0 +1,155 @@
+package birc_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+	"github.com/jpiontek/bitter-irc/birctest"
+)
+
+func TestRecordTraffic(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	digested := make(chan bool, 1)
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, func(m birc.Message, w birc.ChannelWriter) {
+		if m.Command == "PRIVMSG" {
+			digested <- true
+		}
+	})
+	c.Config.Server = server.Addr
+	var traffic bytes.Buffer
+	c.RecordTraffic(&traffic)
+
+	joined := make(chan string, 1)
+	c.OnJoin(func(c *birc.Channel) { joined <- "join" })
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	listening := make(chan error, 1)
+	go func() { listening <- c.Listen() }()
+	expectHooks(t, joined, "join")
+
+	server.Chat("test", "bob", "hi", nil)
+	<-digested
+	c.Shutdown(context.Background())
+	<-listening
+
+	lines := strings.Split(strings.TrimSpace(traffic.String()), "\n")
+	expected := map[string]bool{
+		"> PASS [redacted]": false,
+		"< :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :hi": false,
+		"> QUIT": false,
+	}
+	for _, line := range lines {
+		parts := strings.SplitN(line, " ", 2)
+		if _, err := time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+			t.Errorf("expected a timestamp on %q: %s", line, err)
+		}
+		if _, ok := expected[parts[1]]; ok {
+			expected[parts[1]] = true
+		}
+	}
+	for line, found := range expected {
+		if !found {
+			t.Errorf("expected %q to be recorded, got:\n%s", line, traffic.String())
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	traffic := strings.Join([]string{
+		"2017-10-05T23:36:12Z > PRIVMSG #test :ignored",
+		"2017-10-05T23:36:12Z < PING :tmi.twitch.tv",
+		"2017-10-05T23:36:12Z < :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :!echo one",
+		"2017-10-05T23:36:12.05Z < :bob!bob@bob.tmi.twitch.tv PRIVMSG #test :!echo two",
+	}, "\n")
+
+	var times []time.Time
+	echo := func(m birc.Message, w birc.ChannelWriter) {
+		if strings.HasPrefix(m.Content, "!echo ") {
+			times = append(times, m.Time)
+			w.Send(strings.TrimPrefix(m.Content, "!echo "))
+		}
+	}
+
+	for _, realtime := range []bool{false, true} {
+		times = nil
+		c := birc.NewTwitchChannel("test", "foobar", "abc123", false, echo)
+		r := birctest.NewRecorder("test", "foobar")
+		c.SetWriter(r)
+
+		start := time.Now()
+		if err := c.Replay(context.Background(), strings.NewReader(traffic), realtime); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+
+		r.AssertSent(t, "one", "two")
+		if len(times) != 2 || !times[0].Equal(time.Date(2017, 10, 5, 23, 36, 12, 0, time.UTC)) {
+			t.Errorf("expected messages to keep their recorded time, got %v", times)
+		}
+		if realtime && elapsed < 50*time.Millisecond {
+			t.Errorf("expected a realtime replay to take at least 50ms, took %s", elapsed)
+		}
+	}
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	if err := c.Replay(context.Background(), strings.NewReader("garbage"), false); err == nil {
+		t.Error("expected an invalid record to fail the replay")
+	}
+}
+
+func TestReplayKeepsDispatcher(t *testing.T) {
+	server := birctest.NewServer()
+	defer server.Close()
+
+	// The first message is only digested once the second one is, which
+	// deadlocks a single worker but not the default dispatcher.
+	second := make(chan struct{})
+	digested := make(chan bool, 1)
+	digester := func(m birc.Message, w birc.ChannelWriter) {
+		switch m.Content {
+		case "first":
+			select {
+			case <-second:
+				digested <- true
+			case <-time.After(time.Second):
+				digested <- false
+			}
+		case "second":
+			close(second)
+		}
+	}
+
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false, digester)
+	c.Config.Server = server.Addr
+	if err := c.Replay(context.Background(), strings.NewReader(""), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+	if err := c.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	go c.Listen()
+	if _, err := server.WaitFor(timeout(t), equals("JOIN #test")); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Chat("test", "bob", "first", nil)
+	server.Chat("test", "bob", "second", nil)
+	if !<-digested {
+		t.Error("expected the replay to leave the Channel's dispatcher alone")
+	}
+}
@@ -0,0 +1,53 @@
+package birc
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// watchdog closes a connection that hasn't delivered a line within timeout.
+// Every connection gets its own watchdog, which is kicked for each inbound line.
+type watchdog struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	timeout time.Duration
+	timer   Timer
+	tripped bool
+}
+
+func newWatchdog(conn net.Conn, timeout time.Duration, clock Clock) *watchdog {
+	w := &watchdog{conn: conn, timeout: timeout}
+	w.timer = clock.AfterFunc(timeout, w.trip)
+	return w
+}
+
+func (w *watchdog) trip() {
+	w.mu.Lock()
+	w.tripped = true
+	w.mu.Unlock()
+	w.conn.Close()
+}
+
+// kick records inbound activity and restarts the silence timer.
+func (w *watchdog) kick() {
+	w.timer.Reset(w.timeout)
+}
+
+// stop disarms the watchdog and reports whether it had already closed the connection.
+func (w *watchdog) stop() bool {
+	w.timer.Stop()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.tripped
+}
+
+// watch starts a watchdog for the current connection.
+func (c *Channel) watch() *watchdog {
+	timeout := c.Config.SilenceTimeout
+	if timeout <= 0 {
+		timeout = DefaultSilenceTimeout
+	}
+	conn, _, _ := c.conn()
+	return newWatchdog(conn, timeout, c.Clock())
+}
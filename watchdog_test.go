@@ -0,0 +1,64 @@
+package birc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jpiontek/bitter-irc"
+)
+
+func TestSilentConnectionIsReconnected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	clock := birc.NewFakeClock(time.Date(2017, 10, 5, 12, 0, 0, 0, time.UTC))
+	c := birc.NewTwitchChannel("test", "foobar", "abc123", false)
+	c.Config.Server = l.Addr().String()
+	c.Config.SilenceTimeout = time.Minute
+	c.SetClock(clock)
+
+	// The watchdog is armed and kicked by the time a line reaches the hooks.
+	received := make(chan string, 1)
+	c.OnInbound(func(line string) { received <- line })
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	go c.Listen()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(":tmi.twitch.tv 001 foobar :Welcome, GLHF!\r\n"))
+	<-received
+
+	// The connection then stays silent, the watchdog should give up on it and
+	// dial a second one.
+	clock.Advance(time.Minute - time.Second)
+	if stats := c.Stats(); stats.ForcedReconnects != 0 {
+		t.Fatalf("expected no forced reconnect before the timeout, got %d", stats.ForcedReconnects)
+	}
+	clock.Advance(time.Second)
+	second, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	stats := c.Stats()
+	if stats.ForcedReconnects != 1 {
+		t.Errorf("expected 1 forced reconnect, got %d", stats.ForcedReconnects)
+	}
+	if stats.Reconnects != 0 || stats.Errors != 0 {
+		t.Errorf("expected no other reconnects or errors, got %+v", stats)
+	}
+
+	c.Shutdown(context.Background())
+}